@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/barasher/picture-dispatcher/internal"
 	"github.com/rs/zerolog"
@@ -19,19 +25,62 @@ const (
 
 	defaultLoggingLevel     string = "info"
 	defaultOutputDateFormat string = "2006_01"
+	dateBoundFormat         string = "2006-01-02"
+
+	planFormatTable string = "table"
+	planFormatJSON  string = "json"
+
+	undoCommand string = "undo"
 )
 
 type dateField struct {
-	Field   string `json:"field"`
+	Field       string   `json:"field"`
+	Pattern     string   `json:"pattern"`
+	Patterns    []string `json:"patterns"`
+	Zone        string   `json:"zone"`
+	OffsetField string   `json:"offsetField"`
+}
+
+type fileNamePattern struct {
+	Regex   string `json:"regex"`
 	Pattern string `json:"pattern"`
 }
 
+type rule struct {
+	Name           string      `json:"name"`
+	Globs          []string    `json:"globs"`
+	Extensions     []string    `json:"extensions"`
+	MimeTypes      []string    `json:"mimeTypes"`
+	DateFields     []dateField `json:"dateFields"`
+	OutputTemplate string      `json:"outputTemplate"`
+	TransferMode   string      `json:"transferMode"`
+}
+
 type dispatcherConf struct {
-	LoggingLevel     string      `json:"loggingLevel"`
-	ThreadCount      int         `json:"threadCount"`
-	DateFields       []dateField `json:"dateFields"`
-	OutputDateFormat string      `json:"outputDateFormat"`
-	ExiftoolPath     string      `json:"exiftoolPath"`
+	LoggingLevel     string            `json:"loggingLevel"`
+	ThreadCount      int               `json:"threadCount"`
+	BatchSize        int               `json:"batchSize"`
+	DateFields       []dateField       `json:"dateFields"`
+	FileNamePatterns []fileNamePattern `json:"fileNamePatterns"`
+	IncludeGlobs     []string          `json:"includeGlobs"`
+	ExcludeGlobs     []string          `json:"excludeGlobs"`
+	SkipHidden       bool              `json:"skipHidden"`
+	MaxDepth         int               `json:"maxDepth"`
+	FileTimeFallback string            `json:"fileTimeFallback"`
+	MinDate          string            `json:"minDate"`
+	MaxDate          string            `json:"maxDate"`
+	RejectFuture     bool              `json:"rejectFutureDates"`
+	CollisionPolicy  string            `json:"collisionPolicy"`
+	DuplicatePolicy  string            `json:"duplicatePolicy"`
+	RenamePattern    string            `json:"renamePattern"`
+	TransferMode     string            `json:"transferMode"`
+	OutputDateFormat string            `json:"outputDateFormat"`
+	OutputTemplate   string            `json:"outputTemplate"`
+	OutputZone       string            `json:"outputZone"`
+	Rules            []rule            `json:"rules"`
+	ExiftoolPath     string            `json:"exiftoolPath"`
+	Extractor        string            `json:"metadataExtractor"`
+	MetadataCache    string            `json:"metadataCache"`
 }
 
 func loadConf(confFile string) (dispatcherConf, error) {
@@ -78,15 +127,82 @@ func setLoggingLevel(lvl string) error {
 	return nil
 }
 
+func toDateFields(fields []dateField) []internal.DateField {
+	dFs := []internal.DateField{}
+	for _, v := range fields {
+		dFs = append(dFs, internal.DateField{Field: v.Field, Pattern: v.Pattern, Patterns: v.Patterns, Zone: v.Zone, OffsetField: v.OffsetField})
+	}
+	return dFs
+}
+
+// parseDateBounds parses the bounds of the plausibility window, both days included. An empty
+// bound is not checked.
+func parseDateBounds(minDate string, maxDate string) (time.Time, time.Time, error) {
+	var min, max time.Time
+	var err error
+	if minDate != "" {
+		if min, err = time.Parse(dateBoundFormat, minDate); err != nil {
+			return min, max, fmt.Errorf("unparsable minDate %v: %w", minDate, err)
+		}
+	}
+	if maxDate != "" {
+		if max, err = time.Parse(dateBoundFormat, maxDate); err != nil {
+			return min, max, fmt.Errorf("unparsable maxDate %v: %w", maxDate, err)
+		}
+		max = max.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return min, max, nil
+}
+
+type dispatchPlan struct {
+	LiveVideos []string               `json:"liveVideos"`
+	Moves      []internal.PlannedMove `json:"moves"`
+}
+
+func printPlan(w io.Writer, format string, liveVideos []string, moves []internal.PlannedMove) error {
+	if format == planFormatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(dispatchPlan{LiveVideos: liveVideos, Moves: moves})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tFROM\tTO\tDATE SOURCE\tCOLLISION")
+	for _, v := range liveVideos {
+		fmt.Fprintf(tw, "delete\t%v\t-\t-\t-\n", v)
+	}
+	for _, m := range moves {
+		if m.Duplicate != "" {
+			fmt.Fprintf(tw, "duplicate\t%v\t%v\t%v\t-\n", m.From, m.Duplicate, m.Source)
+			continue
+		}
+		col := m.Collision
+		if col == "" {
+			col = "-"
+		}
+		fmt.Fprintf(tw, "move\t%v\t%v\t%v\t%v\n", m.From, m.To, m.Source, col)
+	}
+	return tw.Flush()
+}
+
 func main() {
 	os.Exit(doMain(os.Args))
 }
 
 func doMain(args []string) int {
+	if len(args) > 1 && args[1] == undoCommand {
+		return doUndo(args[1:])
+	}
+
 	cmd := flag.NewFlagSet("file-dispatcher", flag.ContinueOnError)
 	from := cmd.String("s", "", "Source folder")
 	to := cmd.String("d", "", "Destination folder")
 	confFile := cmd.String("c", "", "Configuration file")
+	dryRun := cmd.Bool("n", false, "Dry-run: print planned moves without modifying anything")
+	planFormat := cmd.String("f", planFormatTable, "Dry-run output format (table, json)")
+	transferMode := cmd.String("m", "", "Transfer mode (move, copy, hardlink, symlink, reflink), overrides configuration")
+	reportFile := cmd.String("report", "", "File where the JSON run report is written")
+	refreshCache := cmd.Bool("refresh-cache", false, "Discard the metadata cache before dispatching")
 
 	err := cmd.Parse(args[1:])
 	if err != nil {
@@ -116,34 +232,139 @@ func doMain(args []string) int {
 		return retConfFailure
 	}
 
+	if *dryRun && *planFormat != planFormatTable && *planFormat != planFormatJSON {
+		log.Error().Msgf("Unsupported dry-run output format (-f): %v", *planFormat)
+		return retConfFailure
+	}
+
 	if *to == "" {
 		*to = filepath.Join(*from, "out")
 		log.Info().Msgf("No destination provided (-s), defaults to %v", *to)
-		if err = os.Mkdir(*to, 0777); err != nil {
-			log.Error().Msgf("error while creating output folder (%v): %v", *to, err)
-			return retConfFailure
+		if !*dryRun {
+			if err = os.Mkdir(*to, 0777); err != nil {
+				log.Error().Msgf("error while creating output folder (%v): %v", *to, err)
+				return retConfFailure
+			}
 		}
 	}
 
-	if err = internal.RemoveLiveVideos(*from); err != nil {
-		log.Error().Msgf("error while removing live videos: %v", err)
-		return retExecFailure
-	}
-
 	ddOpts := []func(*internal.DateDispatcher) error{}
 	ddOpts = append(ddOpts, internal.OptDateOutputFormat(conf.OutputDateFormat))
+	if conf.OutputTemplate != "" {
+		ddOpts = append(ddOpts, internal.OptOutputTemplate(conf.OutputTemplate))
+	}
+	if conf.OutputZone != "" {
+		ddOpts = append(ddOpts, internal.OptOutputZone(conf.OutputZone))
+	}
 	if conf.ThreadCount > 0 {
 		ddOpts = append(ddOpts, internal.OptThreadCount(conf.ThreadCount))
 	}
+	if conf.BatchSize > 0 {
+		ddOpts = append(ddOpts, internal.OptBatchSize(conf.BatchSize))
+	}
 	if conf.ExiftoolPath != "" {
 		ddOpts = append(ddOpts, internal.OptExiftoolPath(conf.ExiftoolPath))
 	}
-	dFs := map[string]string{}
-	for _, v := range conf.DateFields {
-		dFs[v.Field] = v.Pattern
+	if conf.Extractor != "" {
+		ddOpts = append(ddOpts, internal.OptMetadataExtractor(conf.Extractor))
 	}
+	if conf.MetadataCache != "" {
+		if *refreshCache && *dryRun {
+			// dry runs don't modify the cache, it is ignored instead of being discarded
+			log.Info().Msgf("Metadata cache ignored")
+		} else {
+			if *refreshCache {
+				if err := internal.InvalidateMetadataCache(conf.MetadataCache); err != nil {
+					log.Error().Msgf("error while discarding metadata cache: %v", err)
+					return retExecFailure
+				}
+			}
+			ddOpts = append(ddOpts, internal.OptMetadataCache(conf.MetadataCache))
+		}
+	} else if *refreshCache {
+		log.Warn().Msgf("No metadata cache configured, -refresh-cache ignored")
+	}
+	dFs := toDateFields(conf.DateFields)
 	if len(dFs) > 0 {
-		ddOpts = append(ddOpts, internal.OptDateFields(dFs))
+		ddOpts = append(ddOpts, internal.OptOrderedDateFields(dFs))
+	}
+	if len(conf.Rules) > 0 {
+		rules := []internal.Rule{}
+		for _, r := range conf.Rules {
+			rules = append(rules, internal.Rule{
+				Name:           r.Name,
+				Globs:          r.Globs,
+				Extensions:     r.Extensions,
+				MimeTypes:      r.MimeTypes,
+				DateFields:     toDateFields(r.DateFields),
+				OutputTemplate: r.OutputTemplate,
+				TransferMode:   r.TransferMode,
+			})
+		}
+		ddOpts = append(ddOpts, internal.OptRules(rules))
+	}
+
+	fnPs := []internal.FileNamePattern{}
+	for _, v := range conf.FileNamePatterns {
+		fnPs = append(fnPs, internal.FileNamePattern{Regex: v.Regex, Pattern: v.Pattern})
+	}
+	if len(fnPs) > 0 {
+		ddOpts = append(ddOpts, internal.OptFileNamePatterns(fnPs))
+	}
+
+	if len(conf.IncludeGlobs) > 0 {
+		ddOpts = append(ddOpts, internal.OptIncludeGlobs(conf.IncludeGlobs))
+	}
+	if len(conf.ExcludeGlobs) > 0 {
+		ddOpts = append(ddOpts, internal.OptExcludeGlobs(conf.ExcludeGlobs))
+	}
+	if conf.SkipHidden {
+		ddOpts = append(ddOpts, internal.OptSkipHidden())
+	}
+	if conf.MaxDepth != 0 {
+		ddOpts = append(ddOpts, internal.OptMaxDepth(conf.MaxDepth))
+	}
+
+	if conf.FileTimeFallback != "" {
+		ddOpts = append(ddOpts, internal.OptFileTimeFallback(conf.FileTimeFallback))
+	}
+
+	if conf.MinDate != "" || conf.MaxDate != "" {
+		min, max, err := parseDateBounds(conf.MinDate, conf.MaxDate)
+		if err != nil {
+			log.Error().Msgf("Error during configuration file validation: %v", err)
+			return retConfFailure
+		}
+		ddOpts = append(ddOpts, internal.OptDateWindow(min, max))
+	}
+	if conf.RejectFuture {
+		ddOpts = append(ddOpts, internal.OptRejectFutureDates())
+	}
+
+	if conf.CollisionPolicy != "" {
+		ddOpts = append(ddOpts, internal.OptCollisionPolicy(conf.CollisionPolicy))
+	}
+	if conf.DuplicatePolicy != "" {
+		ddOpts = append(ddOpts, internal.OptDuplicatePolicy(conf.DuplicatePolicy))
+	}
+	if conf.RenamePattern != "" {
+		ddOpts = append(ddOpts, internal.OptRenamePattern(conf.RenamePattern))
+	}
+	if *transferMode != "" {
+		conf.TransferMode = *transferMode
+	}
+	if conf.TransferMode != "" {
+		ddOpts = append(ddOpts, internal.OptTransferMode(conf.TransferMode))
+	}
+
+	var journal *internal.Journal
+	if !*dryRun {
+		if journal, err = internal.OpenJournal(*to); err != nil {
+			log.Error().Msgf("error while opening journal: %v", err)
+			return retExecFailure
+		}
+		defer journal.Close()
+		ddOpts = append(ddOpts, internal.OptJournal(journal))
 	}
 
 	dd, err := internal.NewDateDispatcher(ddOpts...)
@@ -152,10 +373,98 @@ func doMain(args []string) int {
 		return retExecFailure
 	}
 
-	if err = dd.Dispatch(*from, *to); err != nil {
-		log.Error().Msgf("error while dispatching date: %v", err)
+	if *dryRun {
+		liveVideos, _, err := dd.ListLiveVideos(*from, *to)
+		if err != nil {
+			log.Error().Msgf("error while listing live videos: %v", err)
+			return retExecFailure
+		}
+		plan, err := dd.Plan(*from, *to)
+		if err != nil {
+			log.Error().Msgf("error while planning dispatch: %v", err)
+			return retExecFailure
+		}
+		if err = printPlan(os.Stdout, *planFormat, liveVideos, plan); err != nil {
+			log.Error().Msgf("error while printing plan: %v", err)
+			return retExecFailure
+		}
+		return retOk
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// the signals are not caught anymore, so that a second Ctrl-C kills the process
+		stop()
+	}()
+
+	removed, kept, err := dd.QuarantineLiveVideos(*from, journal)
+	if err != nil {
+		log.Error().Msgf("error while removing live videos: %v", err)
 		return retExecFailure
 	}
 
+	report, err := dd.DispatchContext(ctx, *from, *to)
+	report.LiveVideosRemoved = removed
+	report.LiveVideosKept = kept
+	ret := retOk
+	if err != nil {
+		log.Error().Msgf("error while dispatching date: %v", err)
+		ret = retExecFailure
+	}
+	if ctx.Err() != nil {
+		log.Warn().Interface("report", report).Msgf("Dispatch interrupted, partial report")
+	}
+
+	if *reportFile != "" {
+		if err = writeReport(*reportFile, report); err != nil {
+			log.Error().Msgf("error while writing report: %v", err)
+			return retExecFailure
+		}
+	}
+
+	return ret
+}
+
+func doUndo(args []string) int {
+	cmd := flag.NewFlagSet("file-dispatcher undo", flag.ContinueOnError)
+	to := cmd.String("d", "", "Destination folder of the dispatch to undo")
+	lvl := cmd.String("l", defaultLoggingLevel, "Logging level")
+
+	err := cmd.Parse(args[1:])
+	if err != nil {
+		if err != flag.ErrHelp {
+			log.Error().Msgf("error while parsing command line arguments: %v", err)
+		}
+		return retConfFailure
+	}
+	if err = setLoggingLevel(*lvl); err != nil {
+		log.Error().Msgf("error while specifying logging level: %v", err)
+		return retConfFailure
+	}
+	if *to == "" {
+		log.Error().Msgf("No destination provided (-d)")
+		return retConfFailure
+	}
+
+	if _, err = internal.Undo(*to); err != nil {
+		log.Error().Msgf("error while undoing dispatch: %v", err)
+		return retExecFailure
+	}
 	return retOk
 }
+
+func writeReport(path string, report internal.Report) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err = enc.Encode(report); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -1,19 +1,24 @@
 package main
 
 import (
+	"encoding/json"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/barasher/picture-dispatcher/internal"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestLoadConf(t *testing.T) {
 	expDateFields := []dateField{
-		{"CreateDate", "2006:01:02 15:04:05"},
-		{"Media Create Date", "2006:01:02 15:04:05"},
+		{Field: "CreateDate", Pattern: "2006:01:02 15:04:05"},
+		{Field: "Media Create Date", Pattern: "2006:01:02 15:04:05"},
 	}
 	var tcs = []struct {
 		tcID                string
@@ -44,6 +49,33 @@ func TestLoadConf(t *testing.T) {
 	}
 }
 
+func TestLoadConfFileNamePatterns(t *testing.T) {
+	c, err := loadConf("testdata/conf/filename_patterns.json")
+	assert.Nil(t, err)
+	exp := []fileNamePattern{
+		{`^(\d{8}_\d{6})`, "20060102_150405"},
+		{`^IMG-(\d{8})-WA\d+`, "20060102"},
+	}
+	assert.Equal(t, exp, c.FileNamePatterns)
+}
+
+func TestParseDateBounds(t *testing.T) {
+	min, max, err := parseDateBounds("2000-01-02", "2030-12-31")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2000, time.January, 2, 0, 0, 0, 0, time.UTC), min)
+	assert.Equal(t, time.Date(2030, time.December, 31, 23, 59, 59, 999999999, time.UTC), max)
+
+	min, max, err = parseDateBounds("", "")
+	assert.Nil(t, err)
+	assert.True(t, min.IsZero())
+	assert.True(t, max.IsZero())
+
+	_, _, err = parseDateBounds("2000/01/02", "")
+	assert.NotNil(t, err)
+	_, _, err = parseDateBounds("", "tomorrow")
+	assert.NotNil(t, err)
+}
+
 func TestSetLoggingLevel(t *testing.T) {
 	var tcs = []struct {
 		tcID       string
@@ -101,7 +133,15 @@ func copy(from, to string) error {
 	return err
 }
 
+// requireExiftool skips the tests relying on exiftool if it is not installed
+func requireExiftool(tb testing.TB) {
+	if _, err := exec.LookPath("exiftool"); err != nil {
+		tb.Skip("exiftool not installed")
+	}
+}
+
 func TestDoMainNominal(t *testing.T) {
+	requireExiftool(t)
 	tmpDir := t.TempDir()
 	inDir := filepath.Join(tmpDir, "in")
 	assert.Nil(t, os.Mkdir(inDir, 0777))
@@ -120,3 +160,257 @@ func TestDoMainNominal(t *testing.T) {
 	checkExist(t, filepath.Join(outDir, "2019+04", "20190404_131804.jpg"), true)
 
 }
+
+func TestDoMainNativeExtractor(t *testing.T) {
+	tmpDir := t.TempDir()
+	inDir := filepath.Join(tmpDir, "in")
+	assert.Nil(t, os.Mkdir(inDir, 0777))
+	jpgFile := filepath.Join(inDir, "20190404_131804.jpg")
+	assert.Nil(t, copy("testdata/input/20190404_131804.jpg", jpgFile))
+	movFile := filepath.Join(inDir, "20190404_131804.MOV")
+	assert.Nil(t, copy("testdata/input/20190404_131804.jpg", movFile))
+	outDir := filepath.Join(tmpDir, "out")
+	assert.Nil(t, os.Mkdir(outDir, 0777))
+
+	ret := doMain([]string{"osef", "-c", "testdata/conf/native.json", "-s", inDir, "-d", outDir})
+	assert.Equal(t, retOk, ret)
+
+	checkExist(t, jpgFile, false)
+	checkExist(t, movFile, false)
+	checkExist(t, filepath.Join(outDir, "2019+04", "20190404_131804.jpg"), true)
+
+}
+
+func TestDoMainCopyKeepsLiveVideos(t *testing.T) {
+	tmpDir := t.TempDir()
+	inDir := filepath.Join(tmpDir, "in")
+	assert.Nil(t, os.Mkdir(inDir, 0777))
+	jpgFile := filepath.Join(inDir, "20190404_131804.jpg")
+	assert.Nil(t, copy("testdata/input/20190404_131804.jpg", jpgFile))
+	movFile := filepath.Join(inDir, "20190404_131804.MOV")
+	assert.Nil(t, copy("testdata/input/20190404_131804.jpg", movFile))
+	outDir := filepath.Join(tmpDir, "out")
+	assert.Nil(t, os.Mkdir(outDir, 0777))
+	reportFile := filepath.Join(tmpDir, "report.json")
+
+	ret := doMain([]string{"osef", "-c", "testdata/conf/native.json", "-s", inDir, "-d", outDir, "-m", "copy", "-report", reportFile})
+	assert.Equal(t, retOk, ret)
+
+	checkExist(t, jpgFile, true)
+	checkExist(t, movFile, true)
+	checkExist(t, filepath.Join(outDir, "2019+04", "20190404_131804.jpg"), true)
+	b, err := os.ReadFile(reportFile)
+	assert.Nil(t, err)
+	var report internal.Report
+	assert.Nil(t, json.Unmarshal(b, &report))
+	assert.Equal(t, 0, report.LiveVideosRemoved)
+	assert.Equal(t, 1, report.LiveVideosKept)
+}
+
+func TestDoMainUndo(t *testing.T) {
+	tmpDir := t.TempDir()
+	inDir := filepath.Join(tmpDir, "in")
+	assert.Nil(t, os.Mkdir(inDir, 0777))
+	jpgFile := filepath.Join(inDir, "20190404_131804.jpg")
+	assert.Nil(t, copy("testdata/input/20190404_131804.jpg", jpgFile))
+	movFile := filepath.Join(inDir, "20190404_131804.MOV")
+	assert.Nil(t, copy("testdata/input/20190404_131804.jpg", movFile))
+	outDir := filepath.Join(tmpDir, "out")
+	assert.Nil(t, os.Mkdir(outDir, 0777))
+
+	ret := doMain([]string{"osef", "-c", "testdata/conf/native.json", "-s", inDir, "-d", outDir})
+	assert.Equal(t, retOk, ret)
+	checkExist(t, jpgFile, false)
+	checkExist(t, movFile, false)
+
+	ret = doMain([]string{"osef", "undo", "-d", outDir})
+	assert.Equal(t, retOk, ret)
+	checkExist(t, jpgFile, true)
+	checkExist(t, movFile, true)
+	checkExist(t, filepath.Join(outDir, "2019+04", "20190404_131804.jpg"), false)
+
+	ret = doMain([]string{"osef", "undo", "-d", outDir})
+	assert.Equal(t, retExecFailure, ret)
+}
+
+func TestDoMainUndoAfterNoOpRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	inDir := filepath.Join(tmpDir, "in")
+	assert.Nil(t, os.Mkdir(inDir, 0777))
+	jpgFile := filepath.Join(inDir, "20190404_131804.jpg")
+	assert.Nil(t, copy("testdata/input/20190404_131804.jpg", jpgFile))
+	outDir := filepath.Join(tmpDir, "out")
+	assert.Nil(t, os.Mkdir(outDir, 0777))
+
+	ret := doMain([]string{"osef", "-c", "testdata/conf/native.json", "-s", inDir, "-d", outDir})
+	assert.Equal(t, retOk, ret)
+	checkExist(t, jpgFile, false)
+	// nothing left to dispatch
+	ret = doMain([]string{"osef", "-c", "testdata/conf/native.json", "-s", inDir, "-d", outDir})
+	assert.Equal(t, retOk, ret)
+
+	ret = doMain([]string{"osef", "undo", "-d", outDir})
+	assert.Equal(t, retOk, ret)
+	checkExist(t, jpgFile, true)
+	checkExist(t, filepath.Join(outDir, "2019+04", "20190404_131804.jpg"), false)
+}
+
+func TestDoMainUndoWithoutDestination(t *testing.T) {
+	ret := doMain([]string{"osef", "undo"})
+	assert.Equal(t, retConfFailure, ret)
+}
+
+func TestDoMainReport(t *testing.T) {
+	tmpDir := t.TempDir()
+	inDir := filepath.Join(tmpDir, "in")
+	assert.Nil(t, os.Mkdir(inDir, 0777))
+	assert.Nil(t, copy("testdata/input/20190404_131804.jpg", filepath.Join(inDir, "20190404_131804.jpg")))
+	assert.Nil(t, copy("testdata/input/20190404_131804.jpg", filepath.Join(inDir, "20190404_131804.MOV")))
+	outDir := filepath.Join(tmpDir, "out")
+	assert.Nil(t, os.Mkdir(outDir, 0777))
+	reportFile := filepath.Join(tmpDir, "report.json")
+
+	ret := doMain([]string{"osef", "-c", "testdata/conf/native.json", "-s", inDir, "-d", outDir, "-report", reportFile})
+	assert.Equal(t, retOk, ret)
+
+	f, err := os.Open(reportFile)
+	assert.Nil(t, err)
+	defer f.Close()
+	var report internal.Report
+	assert.Nil(t, json.NewDecoder(f).Decode(&report))
+	assert.Equal(t, 1, report.FilesScanned)
+	assert.Equal(t, 1, report.FilesTransferred)
+	assert.Equal(t, 1, report.LiveVideosRemoved)
+}
+
+func TestDoMainNonExistingSource(t *testing.T) {
+	ret := doMain([]string{"osef", "-c", "testdata/conf/nominal.json", "-s", "testdata/nonExisting", "-d", t.TempDir()})
+	assert.Equal(t, retExecFailure, ret)
+}
+
+func TestDoMainDryRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	inDir := filepath.Join(tmpDir, "in")
+	assert.Nil(t, os.Mkdir(inDir, 0777))
+	jpgFile := filepath.Join(inDir, "20190404_131804.jpg")
+	assert.Nil(t, copy("testdata/input/20190404_131804.jpg", jpgFile))
+	movFile := filepath.Join(inDir, "20190404_131804.MOV")
+	assert.Nil(t, copy("testdata/input/20190404_131804.jpg", movFile))
+
+	ret := doMain([]string{"osef", "-c", "testdata/conf/native.json", "-s", inDir, "-n", "-f", "json"})
+	assert.Equal(t, retOk, ret)
+
+	checkExist(t, jpgFile, true)
+	checkExist(t, movFile, true)
+	checkExist(t, filepath.Join(inDir, "out"), false)
+}
+
+func TestDoMainDryRunUnsupportedFormat(t *testing.T) {
+	ret := doMain([]string{"osef", "-c", "testdata/conf/nominal.json", "-s", "testdata/input", "-n", "-f", "xml"})
+	assert.Equal(t, retConfFailure, ret)
+}
+
+func TestDoMainUnsupportedTransferMode(t *testing.T) {
+	ret := doMain([]string{"osef", "-c", "testdata/conf/nominal.json", "-s", "testdata/input", "-n", "-m", "teleport"})
+	assert.Equal(t, retExecFailure, ret)
+}
+
+func TestPrintPlan(t *testing.T) {
+	live := []string{"in/a.MOV"}
+	moves := []internal.PlannedMove{
+		{From: "in/a.jpg", To: "out/2019_04/a.jpg", Source: "CreateDate"},
+		{From: "in/b.jpg", To: "out/2019_04/b.jpg", Source: "fileName", Collision: "renamed"},
+	}
+
+	var tcs = []struct {
+		tcID   string
+		format string
+		exp    string
+	}{
+		{
+			tcID:   "table",
+			format: planFormatTable,
+			exp: "ACTION  FROM      TO                 DATE SOURCE  COLLISION\n" +
+				"delete  in/a.MOV  -                  -            -\n" +
+				"move    in/a.jpg  out/2019_04/a.jpg  CreateDate   -\n" +
+				"move    in/b.jpg  out/2019_04/b.jpg  fileName     renamed\n",
+		},
+		{
+			tcID:   "json",
+			format: planFormatJSON,
+			exp: `{
+  "liveVideos": [
+    "in/a.MOV"
+  ],
+  "moves": [
+    {
+      "from": "in/a.jpg",
+      "to": "out/2019_04/a.jpg",
+      "source": "CreateDate"
+    },
+    {
+      "from": "in/b.jpg",
+      "to": "out/2019_04/b.jpg",
+      "source": "fileName",
+      "collision": "renamed"
+    }
+  ]
+}
+`,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			var b strings.Builder
+			assert.Nil(t, printPlan(&b, tc.format, live, moves))
+			assert.Equal(t, tc.exp, b.String())
+		})
+	}
+}
+
+func TestDoMainMetadataCache(t *testing.T) {
+	tmpDir := t.TempDir()
+	inDir := filepath.Join(tmpDir, "in")
+	assert.Nil(t, os.Mkdir(inDir, 0777))
+	assert.Nil(t, copy("testdata/input/20190404_131804.jpg", filepath.Join(inDir, "20190404_131804.jpg")))
+	confFile := filepath.Join(tmpDir, "conf.json")
+	conf := `{"metadataExtractor":"native","dateFields":[{"field":"CreateDate","pattern":"2006:01:02 15:04:05"}],"metadataCache":"` +
+		filepath.ToSlash(filepath.Join(tmpDir, "metadata.jsonl")) + `"}`
+	assert.Nil(t, os.WriteFile(confFile, []byte(conf), 0666))
+	reportFile := filepath.Join(tmpDir, "report.json")
+
+	readReport := func() internal.Report {
+		f, err := os.Open(reportFile)
+		assert.Nil(t, err)
+		defer f.Close()
+		var report internal.Report
+		assert.Nil(t, json.NewDecoder(f).Decode(&report))
+		return report
+	}
+
+	var tcs = []struct {
+		tcID      string
+		refresh   bool
+		expCached int
+	}{
+		{"first", false, 0},
+		{"cached", false, 1},
+		{"refreshed", true, 0},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			args := []string{"osef", "-c", confFile, "-s", inDir, "-d", filepath.Join(tmpDir, "out"), "-m", "copy", "-report", reportFile}
+			if tc.refresh {
+				args = append(args, "-refresh-cache")
+			}
+			assert.Nil(t, os.RemoveAll(filepath.Join(tmpDir, "out")))
+			assert.Nil(t, os.Mkdir(filepath.Join(tmpDir, "out"), 0777))
+			assert.Equal(t, retOk, doMain(args))
+			report := readReport()
+			assert.Equal(t, 1, report.FilesTransferred)
+			assert.Equal(t, tc.expCached, report.CachedFiles)
+		})
+	}
+}
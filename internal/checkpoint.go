@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const checkpointFile = "checkpoint.jsonl"
+
+// checkpointHeader is the first line of the checkpoint : entries are discarded when the
+// configuration changed since they have been recorded
+type checkpointHeader struct {
+	Fingerprint string `json:"fingerprint"`
+}
+
+// checkpointEntry is the date resolution of a file, To is empty if no date has been found.
+// Done is set once the file has been transferred.
+type checkpointEntry struct {
+	File    string    `json:"file"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	To      string    `json:"to"`
+	Source  string    `json:"source"`
+	Date    time.Time `json:"date"`
+	Mode    string    `json:"mode,omitempty"`
+	Done    bool      `json:"done,omitempty"`
+}
+
+// checkpoint records the date resolution of the files of a dispatch, so that an interrupted
+// dispatch can be resumed without extracting metadata again. It is removed once the
+// dispatch is complete. A nil checkpoint records nothing.
+type checkpoint struct {
+	mutex   sync.Mutex
+	store   jsonLines
+	entries map[string]checkpointEntry
+}
+
+// openCheckpoint opens the checkpoint of outputFolder, its entries are discarded if they
+// have been recorded with another configuration (see DateDispatcher.fingerprint)
+func openCheckpoint(outputFolder string, fingerprint string) (*checkpoint, error) {
+	c := checkpoint{
+		store:   jsonLines{name: "checkpoint", path: filepath.Join(outputFolder, stateFolder, checkpointFile)},
+		entries: make(map[string]checkpointEntry),
+	}
+	header := checkpointHeader{Fingerprint: fingerprint}
+	var h checkpointHeader
+	valid := func() bool {
+		if h.Fingerprint != fingerprint {
+			log.Info().Msgf("configuration changed since the interrupted dispatch, checkpoint discarded")
+			return false
+		}
+		return true
+	}
+	err := c.store.load(&h, valid, func(dec *json.Decoder) error {
+		var e checkpointEntry
+		if err := dec.Decode(&e); err != nil {
+			return err
+		}
+		c.entries[e.File] = e
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(c.entries) > 0 {
+		log.Info().Msgf("Resuming interrupted dispatch (%v file(s) already resolved)", len(c.entries))
+	}
+	err = c.store.rewrite(header, func(enc *json.Encoder) error {
+		for _, e := range c.entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = c.store.openAppend(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// lookup returns the recorded resolution of file if the file has not changed since
+func (c *checkpoint) lookup(file string) (checkpointEntry, bool) {
+	if c == nil {
+		return checkpointEntry{}, false
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return checkpointEntry{}, false
+	}
+	c.mutex.Lock()
+	e, found := c.entries[abs]
+	c.mutex.Unlock()
+	if !found {
+		return checkpointEntry{}, false
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.Size() != e.Size || !info.ModTime().Equal(e.ModTime) {
+		return checkpointEntry{}, false
+	}
+	return e, true
+}
+
+func (c *checkpoint) record(ma moveAction) error {
+	if c == nil {
+		return nil
+	}
+	abs, err := filepath.Abs(ma.from)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(ma.from)
+	if err != nil {
+		return err
+	}
+	e := checkpointEntry{File: abs, Size: info.Size(), ModTime: info.ModTime(), To: ma.to, Source: ma.source, Date: ma.date, Mode: ma.mode}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[abs] = e
+	return c.store.append(e)
+}
+
+// done records that file has been transferred, so that a resumed dispatch doesn't transfer
+// it again when the source is kept (transfer modes other than TransferMove)
+func (c *checkpoint) done(file string) error {
+	if c == nil {
+		return nil
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, found := c.entries[abs]
+	if !found {
+		return nil
+	}
+	e.Done = true
+	c.entries[abs] = e
+	return c.store.append(e)
+}
+
+func (c *checkpoint) close() error {
+	if c == nil {
+		return nil
+	}
+	return c.store.close()
+}
+
+// complete closes and removes the checkpoint
+func (c *checkpoint) complete() error {
+	if c == nil {
+		return nil
+	}
+	return c.store.remove()
+}
+
+// resolutionSettings are the settings the date resolution of a file depends on
+type resolutionSettings struct {
+	DateFields        []DateField
+	FileNamePatterns  []FileNamePattern
+	FileTimeFallback  string
+	MinDate           time.Time
+	MaxDate           time.Time
+	RejectFutureDates bool
+	OutputDateFormat  string
+	OutputTemplate    string
+	OutputZone        string
+	Rules             []Rule
+	RenamePattern     string
+	TransferMode      string
+}
+
+// fingerprint identifies the settings the date resolution of a file depends on, so that a
+// checkpoint is not resumed with another configuration
+func (dd *DateDispatcher) fingerprint() string {
+	s := resolutionSettings{
+		DateFields:        dd.dateFields,
+		FileTimeFallback:  dd.fileTimeFallback,
+		MinDate:           dd.minDate,
+		MaxDate:           dd.maxDate,
+		RejectFutureDates: dd.rejectFutureDates,
+		OutputDateFormat:  dd.outputDateFormat,
+		Rules:             []Rule{},
+		RenamePattern:     dd.renamePattern,
+		TransferMode:      dd.transferMode,
+	}
+	for _, f := range dd.fileNameDates {
+		s.FileNamePatterns = append(s.FileNamePatterns, FileNamePattern{Regex: f.re.String(), Pattern: f.pattern})
+	}
+	if dd.outputTemplate != nil && dd.outputTemplate.Tree != nil {
+		s.OutputTemplate = dd.outputTemplate.Tree.Root.String()
+	}
+	if dd.outputZone != nil {
+		s.OutputZone = dd.outputZone.String()
+	}
+	for _, r := range dd.rules {
+		s.Rules = append(s.Rules, r.Rule)
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		// can't happen, the settings only hold serializable values
+		return ""
+	}
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// cleanPartialFiles removes the files left in outputFolder by interrupted copies and
+// returns how many have been removed. The sources of these files are still in place since
+// they are only removed once the copy is complete.
+func cleanPartialFiles(outputFolder string) (int, error) {
+	cleaned := 0
+	err := filepath.Walk(outputFolder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == outputFolder {
+				return nil
+			}
+			return fmt.Errorf("error when browsing file %v: %v", path, err)
+		}
+		if !info.IsDir() && strings.HasSuffix(path, partExt) {
+			log.Warn().Str(fileLogField, path).Msgf("removing file left by an interrupted copy")
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("error while removing %v: %w", path, err)
+			}
+			cleaned++
+		}
+		return nil
+	})
+	return cleaned, err
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckpoint(t *testing.T) {
+	tmpDir := t.TempDir()
+	outDir := filepath.Join(tmpDir, "out")
+	dated := filepath.Join(tmpDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", dated))
+	noDate := filepath.Join(tmpDir, "b.txt")
+	assert.Nil(t, copy("../testdata/input/subFolder/noDate.txt", noDate))
+	modified := filepath.Join(tmpDir, "c.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", modified))
+
+	date := time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)
+	cp, err := openCheckpoint(outDir, "fp")
+	assert.Nil(t, err)
+	assert.Nil(t, cp.record(moveAction{from: dated, to: filepath.Join("2019_04", "a.jpg"), source: "CreateDate", date: date}))
+	assert.Nil(t, cp.record(moveAction{from: noDate}))
+	assert.Nil(t, cp.record(moveAction{from: modified, to: filepath.Join("2019_04", "c.jpg"), source: "CreateDate", date: date}))
+	assert.Nil(t, cp.close())
+
+	mtime := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.Local)
+	assert.Nil(t, os.Chtimes(modified, mtime, mtime))
+
+	cp, err = openCheckpoint(outDir, "fp")
+	assert.Nil(t, err)
+	e, found := cp.lookup(dated)
+	assert.True(t, found)
+	assert.Equal(t, filepath.Join("2019_04", "a.jpg"), e.To)
+	assert.Equal(t, "CreateDate", e.Source)
+	assert.True(t, date.Equal(e.Date))
+	e, found = cp.lookup(noDate)
+	assert.True(t, found)
+	assert.Equal(t, "", e.To)
+	_, found = cp.lookup(modified)
+	assert.False(t, found)
+
+	assert.Nil(t, cp.complete())
+	checkExist(t, filepath.Join(outDir, stateFolder, checkpointFile), false)
+}
+
+func TestCheckpointOtherConfiguration(t *testing.T) {
+	outDir := t.TempDir()
+	file := filepath.Join(outDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", file))
+	cp, err := openCheckpoint(outDir, "fp")
+	assert.Nil(t, err)
+	assert.Nil(t, cp.record(moveAction{from: file, to: filepath.Join("2019_04", "a.jpg"), source: "CreateDate"}))
+	assert.Nil(t, cp.close())
+
+	cp, err = openCheckpoint(outDir, "other")
+	assert.Nil(t, err)
+	_, found := cp.lookup(file)
+	assert.False(t, found)
+	assert.Nil(t, cp.close())
+
+	// the checkpoint has been rewritten for the new configuration
+	cp, err = openCheckpoint(outDir, "fp")
+	assert.Nil(t, err)
+	_, found = cp.lookup(file)
+	assert.False(t, found)
+	assert.Nil(t, cp.close())
+}
+
+func TestFingerprint(t *testing.T) {
+	build := func(opts ...func(*DateDispatcher) error) string {
+		c, err := NewDateDispatcher(opts...)
+		assert.Nil(t, err)
+		return c.fingerprint()
+	}
+	dateFields := OptOrderedDateFields([]DateField{{Field: "CreateDate", Pattern: "2006:01:02 15:04:05"}})
+	ref := build(dateFields)
+	assert.NotEmpty(t, ref)
+	assert.Equal(t, ref, build(dateFields))
+	// settings that don't change the resolution of dates
+	assert.Equal(t, ref, build(dateFields, OptThreadCount(1), OptCollisionPolicy(CollisionSkip)))
+
+	var tcs = []struct {
+		tcID string
+		opt  func(*DateDispatcher) error
+	}{
+		{"dateFields", OptOrderedDateFields([]DateField{{Field: "DateTimeOriginal", Pattern: "2006:01:02 15:04:05"}})},
+		{"outputDateFormat", OptDateOutputFormat("2006")},
+		{"outputTemplate", OptOutputTemplate("{{.Year}}/{{.Name}}{{.Ext}}")},
+		{"outputZone", OptOutputZone("UTC")},
+		{"fileNamePatterns", OptFileNamePatterns([]FileNamePattern{{Regex: `^(\d{8})`, Pattern: "20060102"}})},
+		{"fileTimeFallback", OptFileTimeFallback(FileTimeModification)},
+		{"rules", OptRules([]Rule{{Name: "videos", Extensions: []string{"mp4"}, OutputTemplate: "videos/{{.Year}}/{{.Name}}{{.Ext}}"}})},
+		{"renamePattern", OptRenamePattern("20060102_150405")},
+		{"transferMode", OptTransferMode(TransferCopy)},
+		{"rejectFutureDates", OptRejectFutureDates()},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			assert.NotEqual(t, ref, build(dateFields, tc.opt))
+		})
+	}
+}
+
+func TestCheckpointTruncated(t *testing.T) {
+	outDir := t.TempDir()
+	file := filepath.Join(outDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", file))
+	cp, err := openCheckpoint(outDir, "fp")
+	assert.Nil(t, err)
+	assert.Nil(t, cp.record(moveAction{from: file, to: filepath.Join("2019_04", "a.jpg"), source: "CreateDate"}))
+	assert.Nil(t, cp.close())
+
+	f, err := os.OpenFile(filepath.Join(outDir, stateFolder, checkpointFile), os.O_WRONLY|os.O_APPEND, 0666)
+	assert.Nil(t, err)
+	_, err = f.WriteString(`{"file":"/tmp/trunc`)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	cp, err = openCheckpoint(outDir, "fp")
+	assert.Nil(t, err)
+	_, found := cp.lookup(file)
+	assert.True(t, found)
+	assert.Nil(t, cp.close())
+}
+
+func TestNilCheckpoint(t *testing.T) {
+	var cp *checkpoint
+	_, found := cp.lookup("a.jpg")
+	assert.False(t, found)
+	assert.Nil(t, cp.record(moveAction{from: "a.jpg", to: filepath.Join("2019_04", "a.jpg"), source: "CreateDate"}))
+	assert.Nil(t, cp.complete())
+}
+
+func TestCleanPartialFiles(t *testing.T) {
+	outDir := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(outDir, "2019_04"), 0777))
+	complete := filepath.Join(outDir, "2019_04", "a.jpg")
+	assert.Nil(t, os.WriteFile(complete, []byte("a"), 0666))
+	partial := filepath.Join(outDir, "2019_04", "b.jpg"+partExt)
+	assert.Nil(t, os.WriteFile(partial, []byte("b"), 0666))
+
+	cleaned, err := cleanPartialFiles(outDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, cleaned)
+	checkExist(t, complete, true)
+	checkExist(t, partial, false)
+
+	cleaned, err = cleanPartialFiles(filepath.Join(outDir, "nonExisting"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, cleaned)
+}
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	CollisionSkip      = "skip"
+	CollisionRename    = "rename"
+	CollisionOverwrite = "overwrite"
+	CollisionHash      = "hash"
+
+	defaultCollisionPolicy = CollisionRename
+
+	resolutionSkipped     = "skipped"
+	resolutionRenamed     = "renamed"
+	resolutionOverwritten = "overwritten"
+	resolutionDropped     = "dropped"
+)
+
+func isCollisionPolicy(p string) bool {
+	switch p {
+	case CollisionSkip, CollisionRename, CollisionOverwrite, CollisionHash:
+		return true
+	}
+	return false
+}
+
+// resolveCollision returns the path the file has to be moved to, or an empty path if the
+// file must not be moved. The returned collision is nil if the target does not exist.
+func resolveCollision(policy string, from string, to string) (string, *Collision, error) {
+	if _, err := os.Lstat(to); err != nil {
+		if os.IsNotExist(err) {
+			return to, nil, nil
+		}
+		return "", nil, fmt.Errorf("error while checking %v existence: %w", to, err)
+	}
+
+	c := Collision{From: from, To: to}
+	switch policy {
+	case CollisionSkip:
+		c.Resolution = resolutionSkipped
+		return "", &c, nil
+	case CollisionOverwrite:
+		c.Resolution = resolutionOverwritten
+		return to, &c, nil
+	case CollisionHash:
+		same, err := sameContent(from, to)
+		if err != nil {
+			return "", nil, err
+		}
+		if same {
+			c.Resolution = resolutionDropped
+			return "", &c, nil
+		}
+	}
+
+	renamed, err := availableName(to)
+	if err != nil {
+		return "", nil, err
+	}
+	c.Resolution = resolutionRenamed
+	c.To = renamed
+	return renamed, &c, nil
+}
+
+// availableName suffixes the file name (before the extension) with the first counter
+// that does not match an existing file
+func availableName(path string) (string, error) {
+	return availableNameAmong(path, nil)
+}
+
+// availableNameAmong is availableName, the paths of reserved being considered as existing
+func availableNameAmong(path string, reserved map[string]string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%v_%v%v", base, i, ext)
+		if _, found := reserved[candidate]; found {
+			continue
+		}
+		if _, err := os.Lstat(candidate); err != nil {
+			if os.IsNotExist(err) {
+				return candidate, nil
+			}
+			return "", fmt.Errorf("error while checking %v existence: %w", candidate, err)
+		}
+	}
+}
+
+func sameContent(a, b string) (bool, error) {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	ib, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if ia.Size() != ib.Size() {
+		return false, nil
+	}
+	ha, err := hashFile(a)
+	if err != nil {
+		return false, err
+	}
+	hb, err := hashFile(b)
+	if err != nil {
+		return false, err
+	}
+	return ha == hb, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("error while hashing %v: %w", path, err)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveCollision(t *testing.T) {
+	var tcs = []struct {
+		tcID          string
+		policy        string
+		sameContent   bool
+		existing      bool
+		expTo         string
+		expResolution string
+	}{
+		{"noCollision", CollisionSkip, false, false, "a.jpg", ""},
+		{"skip", CollisionSkip, false, true, "", resolutionSkipped},
+		{"rename", CollisionRename, false, true, "a_2.jpg", resolutionRenamed},
+		{"overwrite", CollisionOverwrite, false, true, "a.jpg", resolutionOverwritten},
+		{"hashIdentical", CollisionHash, true, true, "", resolutionDropped},
+		{"hashDifferent", CollisionHash, false, true, "a_2.jpg", resolutionRenamed},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			from := filepath.Join(tmpDir, "from.jpg")
+			assert.Nil(t, os.WriteFile(from, []byte("content"), 0666))
+			to := filepath.Join(tmpDir, "a.jpg")
+			if tc.existing {
+				content := "other"
+				if tc.sameContent {
+					content = "content"
+				}
+				assert.Nil(t, os.WriteFile(to, []byte(content), 0666))
+				assert.Nil(t, os.WriteFile(filepath.Join(tmpDir, "a_1.jpg"), []byte("other"), 0666))
+			}
+
+			got, col, err := resolveCollision(tc.policy, from, to)
+			assert.Nil(t, err)
+			if tc.expTo == "" {
+				assert.Equal(t, "", got)
+			} else {
+				assert.Equal(t, filepath.Join(tmpDir, tc.expTo), got)
+			}
+			if tc.expResolution == "" {
+				assert.Nil(t, col)
+			} else {
+				assert.NotNil(t, col)
+				assert.Equal(t, tc.expResolution, col.Resolution)
+			}
+		})
+	}
+}
+
+func TestOptCollisionPolicyUnsupported(t *testing.T) {
+	_, err := NewDateDispatcher(OptCollisionPolicy("unknown"))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package internal
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isCrossDevice returns true if err is returned by a rename between file systems
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package internal
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errorNotSameDevice is ERROR_NOT_SAME_DEVICE, returned when renaming a file to another
+// volume
+const errorNotSameDevice = syscall.Errno(17)
+
+// isCrossDevice returns true if err is returned by a rename between volumes
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV) || errors.Is(err, errorNotSameDevice)
+}
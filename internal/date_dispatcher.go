@@ -3,30 +3,90 @@ package internal
 import (
 	"context"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/barasher/go-exiftool"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 const (
-	fileLogField = "file"
+	fileLogField       = "file"
+	dateSourceLogField = "dateSource"
+
+	fileNameDateSource = "fileName"
+
+	FileTimeModification = "mtime"
+	FileTimeChange       = "ctime"
+	FileTimeBirth        = "birthtime"
 )
 
 var defaultThreadCount = runtime.NumCPU()
 var defaultOutputDateFormat = "2006_01"
 var errNoDateFound = fmt.Errorf("No data found")
 
+// DateField describes an exiftool tag holding a date and the layouts used to parse it :
+// Pattern first, then Patterns.
+//
+// Dates without zone information are interpreted in Zone ("UTC", "Local" or an IANA name
+// such as "Europe/Paris"), or in the offset held by the OffsetField tag (e.g.
+// "OffsetTimeOriginal") when the file has it. Without Zone, they are interpreted in the
+// output zone.
+type DateField struct {
+	Field       string
+	Pattern     string
+	Patterns    []string
+	Zone        string
+	OffsetField string
+}
+
+// FileNamePattern describes how to extract a date from a file name : the first capturing
+// group of Regex (or the whole match if there is no group) is parsed using Pattern
+type FileNamePattern struct {
+	Regex   string
+	Pattern string
+}
+
+type fileNameDate struct {
+	re      *regexp.Regexp
+	pattern string
+}
+
 type DateDispatcher struct {
-	threadCount      int
-	outputDateFormat string
-	dateFields       map[string]string
-	exiftoolPath     string
+	threadCount       int
+	batchSize         int
+	outputDateFormat  string
+	outputTemplate    *template.Template
+	dateFields        []DateField
+	zones             map[string]*time.Location
+	outputZone        *time.Location
+	minDate           time.Time
+	maxDate           time.Time
+	rejectFutureDates bool
+	fileNameDates     []fileNameDate
+	fileTimeFallback  string
+	collisionPolicy   string
+	renamePattern     string
+	transferMode      string
+	rules             []rule
+	duplicatePolicy   string
+	metadataCachePath string
+	includeGlobs      []string
+	excludeGlobs      []string
+	skipHidden        bool
+	maxDepth          int
+	journal           *Journal
+	exiftoolPath      string
+	extractor         string
+	extractorFactory  ExtractorFactory
 }
 
 func OptThreadCount(size int) func(*DateDispatcher) error {
@@ -36,15 +96,183 @@ func OptThreadCount(size int) func(*DateDispatcher) error {
 	}
 }
 
+// OptBatchSize sets how many files a worker sends to exiftool per request
+func OptBatchSize(size int) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		if size < 1 {
+			return fmt.Errorf("batch size must be positive (%v)", size)
+		}
+		c.batchSize = size
+		return nil
+	}
+}
+
+// OptDateFields registers date fields from a map. Since a map has no order, fields are
+// appended sorted by name so that the chosen date is at least stable between runs.
+//
+// Deprecated: use OptOrderedDateFields to control priority.
 func OptDateFields(fields map[string]string) func(*DateDispatcher) error {
 	return func(c *DateDispatcher) error {
-		for f, p := range fields {
-			c.dateFields[f] = p
+		names := make([]string, 0, len(fields))
+		for f := range fields {
+			names = append(names, f)
+		}
+		sort.Strings(names)
+		for _, f := range names {
+			c.dateFields = append(c.dateFields, DateField{Field: f, Pattern: fields[f]})
+		}
+		return nil
+	}
+}
+
+// OptOrderedDateFields registers date fields by priority: the first field found in the
+// metadata of a file wins.
+func OptOrderedDateFields(fields []DateField) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		for _, f := range fields {
+			if err := c.checkDateField(f); err != nil {
+				return err
+			}
+			c.dateFields = append(c.dateFields, f)
 		}
 		return nil
 	}
 }
 
+// checkDateField validates a date field and loads its zone
+func (c *DateDispatcher) checkDateField(f DateField) error {
+	if f.Field == "" {
+		return fmt.Errorf("empty date field name")
+	}
+	if len(f.layouts()) == 0 {
+		return fmt.Errorf("no pattern for date field %v", f.Field)
+	}
+	if f.Zone != "" {
+		loc, err := time.LoadLocation(f.Zone)
+		if err != nil {
+			return fmt.Errorf("unknown zone %v for date field %v: %w", f.Zone, f.Field, err)
+		}
+		c.zones[f.Zone] = loc
+	}
+	return nil
+}
+
+// OptOutputZone defines the zone ("UTC", "Local" or an IANA name such as "Europe/Paris")
+// dates are converted to before formatting the output folders and file names. Dates
+// without zone information are interpreted in this zone. Dates are not converted by
+// default.
+func OptOutputZone(zone string) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return fmt.Errorf("unknown output zone %v: %w", zone, err)
+		}
+		c.outputZone = loc
+		return nil
+	}
+}
+
+// OptDateWindow defines the plausibility window of the dates : dates before min or after
+// max are considered as missing so that the next date source is tried. A zero bound is not
+// checked.
+func OptDateWindow(min time.Time, max time.Time) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		if !min.IsZero() && !max.IsZero() && max.Before(min) {
+			return fmt.Errorf("invalid date window: %v is after %v", min, max)
+		}
+		c.minDate = min
+		c.maxDate = max
+		return nil
+	}
+}
+
+// OptRejectFutureDates considers dates more than a day in the future as missing, so that
+// the next date source is tried
+func OptRejectFutureDates() func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		c.rejectFutureDates = true
+		return nil
+	}
+}
+
+// OptFileNamePatterns registers patterns used to extract a date from the file name when
+// none of the date fields is found. Patterns are tried in order. Dates are interpreted in
+// the output zone.
+func OptFileNamePatterns(patterns []FileNamePattern) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		for _, p := range patterns {
+			re, err := regexp.Compile(p.Regex)
+			if err != nil {
+				return fmt.Errorf("error while compiling file name regex %v: %w", p.Regex, err)
+			}
+			c.fileNameDates = append(c.fileNameDates, fileNameDate{re: re, pattern: p.Pattern})
+		}
+		return nil
+	}
+}
+
+// OptFileTimeFallback enables, as a last resort, the use of a file system date
+// (FileTimeModification, FileTimeChange or FileTimeBirth). When the requested date is not
+// available on the platform, the modification time is used.
+func OptFileTimeFallback(kind string) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		switch kind {
+		case FileTimeModification, FileTimeChange, FileTimeBirth:
+			c.fileTimeFallback = kind
+			return nil
+		default:
+			return fmt.Errorf("unsupported file time fallback: %v", kind)
+		}
+	}
+}
+
+// OptCollisionPolicy defines what happens when a file with the same name already exists in
+// the output folder : CollisionSkip, CollisionRename (default), CollisionOverwrite or
+// CollisionHash (the file is dropped if both contents are identical, renamed otherwise)
+func OptCollisionPolicy(policy string) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		if !isCollisionPolicy(policy) {
+			return fmt.Errorf("unsupported collision policy: %v", policy)
+		}
+		c.collisionPolicy = policy
+		return nil
+	}
+}
+
+// OptRenamePattern renames the dispatched files after their date, formatted with pattern
+// (based on golang time layouts, e.g. "20060102_150405"), the extension is kept. Files of
+// a same-second burst are suffixed with their sub-second part if known, with a counter
+// otherwise.
+func OptRenamePattern(pattern string) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		if err := checkRenamePattern(pattern); err != nil {
+			return err
+		}
+		c.renamePattern = pattern
+		return nil
+	}
+}
+
+// OptTransferMode defines how files are transferred to the output folder : TransferMove
+// (default), TransferCopy, TransferHardlink, TransferSymlink or TransferReflink
+func OptTransferMode(mode string) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		if _, found := transferModes[mode]; !found {
+			return fmt.Errorf("unsupported transfer mode: %v", mode)
+		}
+		c.transferMode = mode
+		return nil
+	}
+}
+
+// OptJournal records every transfer in the journal, so that the dispatch can be undone
+func OptJournal(j *Journal) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		c.journal = j
+		return nil
+	}
+}
+
 func OptDateOutputFormat(pattern string) func(*DateDispatcher) error {
 	return func(c *DateDispatcher) error {
 		c.outputDateFormat = pattern
@@ -52,6 +280,21 @@ func OptDateOutputFormat(pattern string) func(*DateDispatcher) error {
 	}
 }
 
+// OptOutputTemplate defines the path, relative to the output folder, of the dispatched files
+// as a text/template rendered with PathData (e.g.
+// "{{.Year}}/{{.Month}}-{{.MonthName}}/{{.Camera.Model}}/{{.Name}}{{.Ext}}"). It takes
+// precedence over the output date format.
+func OptOutputTemplate(tpl string) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		t, err := parseOutputTemplate(tpl)
+		if err != nil {
+			return fmt.Errorf("error while parsing output template: %w", err)
+		}
+		c.outputTemplate = t
+		return nil
+	}
+}
+
 func OptExiftoolPath(path string) func(*DateDispatcher) error {
 	return func(c *DateDispatcher) error {
 		c.exiftoolPath = path
@@ -62,8 +305,13 @@ func OptExiftoolPath(path string) func(*DateDispatcher) error {
 func NewDateDispatcher(classOpts ...func(*DateDispatcher) error) (*DateDispatcher, error) {
 	c := DateDispatcher{
 		threadCount:      runtime.NumCPU(),
-		dateFields:       make(map[string]string),
+		batchSize:        defaultBatchSize,
+		dateFields:       []DateField{},
+		zones:            make(map[string]*time.Location),
 		outputDateFormat: defaultOutputDateFormat,
+		collisionPolicy:  defaultCollisionPolicy,
+		transferMode:     defaultTransferMode,
+		extractor:        defaultExtractor,
 	}
 	for _, opt := range classOpts {
 		if err := opt(&c); err != nil {
@@ -73,85 +321,198 @@ func NewDateDispatcher(classOpts ...func(*DateDispatcher) error) (*DateDispatche
 	return &c, nil
 }
 
-func (dd *DateDispatcher) Dispatch(inputFolder string, outputFolder string) error {
-	ctx, cancel := context.WithCancel(context.Background())
+// Dispatch moves the files of inputFolder to outputFolder. The report is returned even if
+// an error occurred.
+//
+// Files left by interrupted copies are removed from outputFolder first. The date
+// resolution and the transfer of each file are checkpointed in outputFolder, so that an
+// interrupted dispatch resumes without extracting metadata or transferring files again. The
+// checkpoint is only kept if the dispatch is interrupted (see DispatchContext).
+func (dd *DateDispatcher) Dispatch(inputFolder string, outputFolder string) (Report, error) {
+	return dd.DispatchContext(context.Background(), inputFolder, outputFolder)
+}
+
+// DispatchContext is Dispatch, interrupted when ctx is canceled : the transfer in progress
+// is completed but no new one is started, and the partial report is returned with an error.
+func (dd *DateDispatcher) DispatchContext(ctx context.Context, inputFolder string, outputFolder string) (Report, error) {
+	cleaned, err := cleanPartialFiles(outputFolder)
+	if err != nil {
+		return Report{}, fmt.Errorf("error while cleaning interrupted copies: %w", err)
+	}
+	cp, err := openCheckpoint(outputFolder, dd.fingerprint())
+	if err != nil {
+		return Report{}, err
+	}
+
+	report, err := dd.run(ctx, inputFolder, outputFolder, cp, false, func(ctx context.Context, cancel context.CancelFunc, outputFolder string, actionChan chan moveAction, stats *dispatchStats) {
+		dd.moveFiles(ctx, cancel, outputFolder, actionChan, cp, stats)
+	})
+	report.PartialFilesCleaned = cleaned
+	if ctx.Err() != nil {
+		// the dispatch has been interrupted, the checkpoint is kept to resume it
+		if cErr := cp.close(); cErr != nil {
+			log.Warn().Msgf("error while closing checkpoint: %v", cErr)
+		}
+		return report, err
+	}
+	if cErr := cp.complete(); cErr != nil {
+		log.Warn().Msgf("error while removing checkpoint: %v", cErr)
+	}
+	return report, err
+}
+
+// Plan computes the moves that Dispatch would perform, without modifying anything
+func (dd *DateDispatcher) Plan(inputFolder string, outputFolder string) ([]PlannedMove, error) {
+	var plan []PlannedMove
+	_, err := dd.run(context.Background(), inputFolder, outputFolder, nil, true, func(ctx context.Context, cancel context.CancelFunc, outputFolder string, actionChan chan moveAction, stats *dispatchStats) {
+		plan = dd.planFiles(ctx, cancel, outputFolder, actionChan)
+	})
+	return plan, err
+}
+
+// run executes the pipeline (listing files, guessing dates, last stage) and returns a
+// *DispatchError if anything went wrong. The metadata cache is left untouched if readOnly.
+func (dd *DateDispatcher) run(parentCtx context.Context, inputFolder string, outputFolder string, cp *checkpoint, readOnly bool, lastStage func(context.Context, context.CancelFunc, string, chan moveAction, *dispatchStats)) (Report, error) {
+	var mc *metadataCache
+	if dd.metadataCachePath != "" {
+		tags, all := dd.relevantTags()
+		var err error
+		if mc, err = openMetadataCache(dd.metadataCachePath, tags, all, readOnly); err != nil {
+			return Report{}, err
+		}
+		defer func() {
+			if err := mc.close(); err != nil {
+				log.Warn().Msgf("error while closing metadata cache: %v", err)
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(parentCtx)
+	defer cancel()
 	fileChan := make(chan string, dd.threadCount)
 	actionChan := make(chan moveAction, dd.threadCount)
+	stats := newDispatchStats()
 
 	var wg sync.WaitGroup
 	wg.Add(3)
 
 	go func() { // list files
-		dd.listFiles(ctx, cancel, inputFolder, fileChan)
+		stats.addError(dd.listFiles(ctx, cancel, inputFolder, outputFolder, fileChan, stats))
 		defer wg.Done()
 	}()
 
 	go func() {
-		dd.getMoveActions(ctx, cancel, fileChan, actionChan)
+		stats.addError(dd.getMoveActions(ctx, cancel, fileChan, actionChan, cp, mc, stats))
 		defer wg.Done()
 	}()
 
 	go func() {
-		dd.moveFiles(ctx, cancel, outputFolder, actionChan)
+		lastStage(ctx, cancel, outputFolder, actionChan, stats)
 		defer wg.Done()
 	}()
 
 	wg.Wait()
-	return nil
+	if err := parentCtx.Err(); err != nil {
+		stats.addError(fmt.Errorf("dispatch interrupted: %w", err))
+	}
+	return stats.buildReport(), stats.err()
 }
 
-func (dd *DateDispatcher) listFiles(ctx context.Context, cancel context.CancelFunc, inputFolder string, filesChan chan string) {
+// listFiles sends the files of inputFolder that pass the filters, outputFolder is not
+// browsed if it sits inside inputFolder
+func (dd *DateDispatcher) listFiles(ctx context.Context, cancel context.CancelFunc, inputFolder string, outputFolder string, filesChan chan string, stats *dispatchStats) error {
 	defer close(filesChan)
 	fileCount := 0
+	excludedCount := 0
+	filter := dd.newFileFilter(inputFolder, outputFolder)
 	var err2 error
 
 	err2 = filepath.Walk(inputFolder, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return fmt.Errorf("error when browsing file %v: %v", path, err)
 		}
+		if skip, skipErr := filter.skip(path, info); skip {
+			log.Debug().Msgf("%v excluded", path)
+			if !info.IsDir() {
+				excludedCount++
+			}
+			return skipErr
+		}
 		if !info.IsDir() {
 			select {
 			case <-ctx.Done():
-				return nil
-			default:
-				filesChan <- path
+				return ctx.Err()
+			case filesChan <- path:
 				fileCount++
 				log.Debug().Msgf("New file to extract: %v", path)
 			}
 		}
 		return nil
 	})
+	log.Info().Msgf("%v file(s) found, %v excluded", fileCount, excludedCount)
+	stats.update(func(r *Report) {
+		r.FilesScanned = fileCount
+		r.FilesExcluded = excludedCount
+	})
 
+	if err2 == context.Canceled {
+		// canceled by another stage, that reports its own error
+		return nil
+	}
 	if err2 != nil {
 		cancel()
 		log.Error().Msgf("%v", err2)
+		return err2
 	}
-	log.Info().Msgf("%v file(s) found", fileCount)
+	return nil
 }
 
+// moveAction describes a file to transfer, to is relative to the output folder
 type moveAction struct {
-	from string
-	to   string
+	from   string
+	to     string
+	source string
+	date   time.Time
+	// mode is the transfer mode of the file, the dispatcher one if empty
+	mode string
 }
 
-func (dd *DateDispatcher) getMoveActions(ctx context.Context, cancel context.CancelFunc, filesChan chan string, actionChan chan moveAction) error {
+// getMoveActions returns an error if none of the workers could be started. Each worker sends
+// the files available in filesChan to its metadata extractor by batches, restricted to the
+// tags the dispatcher needs.
+func (dd *DateDispatcher) getMoveActions(ctx context.Context, cancel context.CancelFunc, filesChan chan string, actionChan chan moveAction, cp *checkpoint, mc *metadataCache, stats *dispatchStats) error {
+	tags, all := dd.relevantTags()
+	if all {
+		tags = nil
+	}
+	newExtractor := dd.newExtractorFactory()
 	wg := sync.WaitGroup{}
 	wg.Add(dd.threadCount)
+	var initMutex sync.Mutex
+	var initErr error
+	initFailures := 0
 	for i := 0; i < dd.threadCount; i++ {
 		go func(thId int) {
 			defer wg.Done()
 			l := log.With().Int("threadId", thId).Logger()
 
-			opts := []func(*exiftool.Exiftool) error{}
-			if dd.exiftoolPath != "" {
-				opts = append(opts, exiftool.SetExiftoolBinaryPath(dd.exiftoolPath))
-			}
-			exif, err := exiftool.NewExiftool(opts...)
+			extractor, err := newExtractor(tags)
 			if err != nil {
-				l.Error().Msgf("error while initializing go-exiftool: %v", err)
+				l.Error().Msgf("error while initializing metadata extractor: %v", err)
+				initMutex.Lock()
+				defer initMutex.Unlock()
+				initFailures++
+				initErr = err
+				if initFailures == dd.threadCount {
+					cancel()
+				}
 				return
 			}
-			defer exif.Close()
+			defer func() {
+				if err := extractor.Close(); err != nil {
+					l.Warn().Msgf("%v", err)
+				}
+			}()
 
 			for {
 				select {
@@ -161,21 +522,51 @@ func (dd *DateDispatcher) getMoveActions(ctx context.Context, cancel context.Can
 					if !found {
 						return
 					}
+					batch := dd.nextBatch(filesChan, file)
 
-					fm := exif.ExtractMetadata(file)
-					if fm[0].Err != nil {
-						l.Warn().Str(fileLogField, file).Msgf("error while extracting metadata: %v", fm[0].Err)
-						continue
+					fms := make([]exiftool.FileMetadata, 0, len(batch))
+					toExtract := []string{}
+					for _, file := range batch {
+						if e, found := cp.lookup(file); found {
+							stats.update(func(r *Report) { r.ResumedFiles++ })
+							if e.Done {
+								l.Debug().Str(fileLogField, file).Msgf("already transferred, skipped")
+								continue
+							}
+							if e.To == "" {
+								stats.update(func(r *Report) { r.SkippedNoDate++ })
+								continue
+							}
+							actionChan <- moveAction{from: file, to: e.To, source: e.Source, date: e.Date, mode: e.Mode}
+							continue
+						}
+						if fields, found := mc.lookup(file); found {
+							stats.update(func(r *Report) { r.CachedFiles++ })
+							fms = append(fms, exiftool.FileMetadata{File: file, Fields: fields})
+							continue
+						}
+						toExtract = append(toExtract, file)
 					}
 
-					if d, err := dd.guessDate(fm[0]); err != nil {
-						if err != errNoDateFound {
-							l.Error().Str(fileLogField, file).Msgf("error while generating moveAction %v", err)
+					if len(toExtract) > 0 {
+						for _, fm := range extractor.ExtractMetadata(toExtract...) {
+							if fm.Err != nil {
+								// the date may still be found by the fallbacks (file name, file time)
+								l.Warn().Str(fileLogField, fm.File).Msgf("error while extracting metadata: %v", fm.Err)
+								fm.Fields = nil
+								fms = append(fms, fm)
+								continue
+							}
+							if err := mc.record(fm.File, fm.Fields); err != nil {
+								l.Warn().Str(fileLogField, fm.File).Msgf("error while caching metadata: %v", err)
+							}
+							fms = append(fms, fm)
 						}
-					} else {
-						actionChan <- moveAction{
-							from: file,
-							to:   d.Format(dd.outputDateFormat),
+					}
+
+					for _, fm := range fms {
+						if ma, found := dd.resolveMoveAction(l, fm, cp, stats); found {
+							actionChan <- ma
 						}
 					}
 				}
@@ -185,69 +576,390 @@ func (dd *DateDispatcher) getMoveActions(ctx context.Context, cancel context.Can
 	}
 	wg.Wait()
 	close(actionChan)
+	if initFailures == dd.threadCount {
+		return fmt.Errorf("no metadata extraction worker could be started: %w", initErr)
+	}
 	return nil
 }
 
-func (dd *DateDispatcher) guessDate(fm exiftool.FileMetadata) (time.Time, error) {
-	for field, pattern := range dd.dateFields {
-		if val, found := fm.Fields[field]; found {
-			t, err := time.Parse(pattern, val.(string))
-			if err != nil {
-				return time.Time{}, fmt.Errorf("error when parsing date %v: %v", val.(string), err)
+// nextBatch returns file followed by the files immediately available in filesChan, up to
+// the batch size, so that a batch never waits for the file walker
+func (dd *DateDispatcher) nextBatch(filesChan chan string, file string) []string {
+	batch := []string{file}
+	for len(batch) < dd.batchSize {
+		select {
+		case f, found := <-filesChan:
+			if !found {
+				return batch
 			}
-			return t, nil
+			batch = append(batch, f)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// resolveMoveAction resolves the date and the destination of a file, found is false if the
+// file has to be skipped. If the metadata could not be extracted (fm.Err), the date can
+// only come from the fallbacks, the file is reported as a metadata error if none applies.
+func (dd *DateDispatcher) resolveMoveAction(l zerolog.Logger, fm exiftool.FileMetadata, cp *checkpoint, stats *dispatchStats) (moveAction, bool) {
+	file := fm.File
+	settings := dd.settings(file, fm.Fields)
+	if settings.rule != "" {
+		l.Debug().Str(fileLogField, file).Msgf("rule %v applied", settings.rule)
+	}
+	d, src, rejected, err := dd.guessDateWithRejections(fm, settings.dateFields)
+	if rejected > 0 {
+		stats.update(func(r *Report) { r.ImplausibleDates += rejected })
+	}
+	if err != nil {
+		if err == errNoDateFound && fm.Err != nil {
+			// not checkpointed, so that the extraction is tried again by a resumed dispatch
+			l.Error().Str(fileLogField, file).Msgf("no date found without metadata, file skipped")
+			stats.addFileError(metadataFileError)
+		} else if err == errNoDateFound {
+			l.Info().Str(fileLogField, file).Msgf("no date found, file skipped")
+			stats.update(func(r *Report) { r.SkippedNoDate++ })
+			dd.checkpoint(l, cp, moveAction{from: file})
+		} else {
+			l.Error().Str(fileLogField, file).Msgf("error while generating moveAction %v", err)
+			stats.addFileError(dateFileError)
 		}
+		return moveAction{}, false
 	}
-	return time.Time{}, errNoDateFound
+	if isFileTimeSource(src) {
+		l.Warn().Str(fileLogField, file).Str(dateSourceLogField, src).Msgf("low confidence date, based on file system %v", src)
+	}
+	to, err := dd.destination(settings.outputTemplate, file, d, src, fm.Fields)
+	if err != nil {
+		l.Error().Str(fileLogField, file).Msgf("error while generating moveAction %v", err)
+		stats.addFileError(destinationFileError)
+		return moveAction{}, false
+	}
+	ma := moveAction{
+		from:   file,
+		to:     to,
+		source: src,
+		date:   d,
+	}
+	if settings.transferMode != dd.transferMode {
+		ma.mode = settings.transferMode
+	}
+	dd.checkpoint(l, cp, ma)
+	return ma, true
+}
+
+// guessDate returns the date of a file, converted to the output zone if any, and its source
+func (dd *DateDispatcher) guessDate(fm exiftool.FileMetadata) (time.Time, string, error) {
+	t, src, _, err := dd.guessDateWithRejections(fm, dd.dateFields)
+	return t, src, err
 }
 
-func (dd *DateDispatcher) moveFiles(ctx context.Context, cancel context.CancelFunc, outputFolder string, actionChan chan moveAction) {
+// guessDateWithRejections is guessDate based on the given date fields, it also returns how
+// many dates outside the plausibility window have been ignored
+func (dd *DateDispatcher) guessDateWithRejections(fm exiftool.FileMetadata, dateFields []DateField) (time.Time, string, int, error) {
+	t, src, rejected, err := dd.guessRawDate(fm, dateFields)
+	if err == nil && dd.outputZone != nil {
+		t = t.In(dd.outputZone)
+	}
+	return t, src, rejected, err
+}
+
+func (dd *DateDispatcher) guessRawDate(fm exiftool.FileMetadata, dateFields []DateField) (time.Time, string, int, error) {
+	var unparsable error
+	rejected := 0
+	implausible := func(t time.Time, src string) bool {
+		if dd.plausible(t) {
+			return false
+		}
+		log.Info().Str(fileLogField, fm.File).Str(dateSourceLogField, src).Msgf("implausible date %v ignored", t)
+		rejected++
+		return true
+	}
+	for _, df := range dateFields {
+		val, found := fm.Fields[df.Field]
+		if !found {
+			continue
+		}
+		t, found, err := dd.parseDateField(df, val, fm)
+		if err != nil {
+			log.Warn().Str(fileLogField, fm.File).Msgf("%v, trying next date source", err)
+			unparsable = err
+			continue
+		}
+		if found && !implausible(t, df.Field) {
+			return t, df.Field, rejected, nil
+		}
+	}
+	if t, found := dd.guessDateFromFileName(fm.File); found && !implausible(t, fileNameDateSource) {
+		return t, fileNameDateSource, rejected, nil
+	}
+	if dd.fileTimeFallback != "" {
+		t, src, err := dd.guessDateFromFileTime(fm.File)
+		if err != nil {
+			return t, src, rejected, err
+		}
+		if !implausible(t, src) {
+			return t, src, rejected, nil
+		}
+	}
+	if unparsable != nil {
+		return time.Time{}, "", rejected, unparsable
+	}
+	return time.Time{}, "", rejected, errNoDateFound
+}
+
+// parseDateField parses the value of a date field with each of its layouts. Sentinel values
+// are considered as missing dates.
+func (dd *DateDispatcher) parseDateField(df DateField, val interface{}, fm exiftool.FileMetadata) (time.Time, bool, error) {
+	vals := dateValues(val)
+	if vals == nil {
+		return time.Time{}, false, fmt.Errorf("unsupported value %v (%T) for %v", val, val, df.Field)
+	}
+	loc := dd.fieldZone(df, fm)
+	var lastErr error
+	for _, v := range vals {
+		if isSentinelDate(v) {
+			log.Debug().Str(fileLogField, fm.File).Msgf("sentinel value %q for %v ignored", v, df.Field)
+			continue
+		}
+		for _, layout := range df.layouts() {
+			t, err := time.ParseInLocation(layout, v, loc)
+			if err == nil {
+				return t, true, nil
+			}
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return time.Time{}, false, fmt.Errorf("error when parsing date %v of %v: %v", val, df.Field, lastErr)
+	}
+	return time.Time{}, false, nil
+}
+
+// fieldZone returns the zone a date field without zone information is interpreted in
+func (dd *DateDispatcher) fieldZone(df DateField, fm exiftool.FileMetadata) *time.Location {
+	if df.OffsetField != "" {
+		if val, found := fm.Fields[df.OffsetField]; found {
+			if loc, err := parseOffset(fmt.Sprintf("%v", val)); err == nil {
+				return loc
+			}
+			log.Debug().Str(fileLogField, fm.File).Msgf("unparsable offset %v in %v, ignored", val, df.OffsetField)
+		}
+	}
+	if loc, found := dd.zones[df.Zone]; found {
+		return loc
+	}
+	return dd.defaultZone()
+}
+
+// defaultZone is the zone dates without zone information are interpreted in
+func (dd *DateDispatcher) defaultZone() *time.Location {
+	if dd.outputZone != nil {
+		return dd.outputZone
+	}
+	return time.UTC
+}
+
+// parseOffset parses an EXIF offset such as "+02:00" or "Z"
+func parseOffset(offset string) (*time.Location, error) {
+	t, err := time.Parse("Z07:00", strings.TrimSpace(offset))
+	if err != nil {
+		return nil, err
+	}
+	name, sec := t.Zone()
+	return time.FixedZone(name, sec), nil
+}
+
+func (dd *DateDispatcher) guessDateFromFileName(file string) (time.Time, bool) {
+	name := filepath.Base(file)
+	for _, fnd := range dd.fileNameDates {
+		m := fnd.re.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		val := m[0]
+		if len(m) > 1 {
+			val = m[1]
+		}
+		if t, err := time.ParseInLocation(fnd.pattern, val, dd.defaultZone()); err == nil {
+			return t, true
+		}
+		log.Debug().Str(fileLogField, file).Msgf("%v matches %v but can't be parsed with %v", name, fnd.re, fnd.pattern)
+	}
+	return time.Time{}, false
+}
+
+func (dd *DateDispatcher) guessDateFromFileTime(file string) (time.Time, string, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("error while reading file system dates: %w", err)
+	}
+	switch dd.fileTimeFallback {
+	case FileTimeChange:
+		if t, ok := changeTime(info); ok {
+			return t, FileTimeChange, nil
+		}
+	case FileTimeBirth:
+		if t, ok := birthTime(info); ok {
+			return t, FileTimeBirth, nil
+		}
+	}
+	return info.ModTime(), FileTimeModification, nil
+}
+
+func isFileTimeSource(src string) bool {
+	return src == FileTimeModification || src == FileTimeChange || src == FileTimeBirth
+}
+
+func (dd *DateDispatcher) moveFiles(ctx context.Context, cancel context.CancelFunc, outputFolder string, actionChan chan moveAction, cp *checkpoint, stats *dispatchStats) {
 	moveCount := 0
+	collisions := []Collision{}
 	dirs := make(map[string]bool)
+	names := newRenamer(dd.renamePattern)
+	if names != nil {
+		actionChan = sortedActions(actionChan)
+	}
+	idx := dd.duplicateIndex(outputFolder, stats)
+	quarantineID := dd.quarantineID()
+	canceled := false
 	for ma := range actionChan {
 		l := log.With().Str(fileLogField, ma.from).Logger()
 		select {
 		case <-ctx.Done():
-			log.Info().Msgf("moveFiles canceled")
+			// the channel is drained so that the previous stages can end
+			if !canceled {
+				log.Info().Msgf("moveFiles canceled")
+				canceled = true
+			}
 		default:
-			if _, found := dirs[ma.to]; !found {
-				if err := os.MkdirAll(filepath.Join(outputFolder, ma.to), 0777); err != nil {
+			mode := dd.transferMode
+			if ma.mode != "" {
+				mode = ma.mode
+			}
+			name := names.rename(ma.to, ma.date)
+			target := filepath.Join(outputFolder, name)
+			dir := filepath.Dir(target)
+			if _, found := dirs[dir]; !found {
+				if err := os.MkdirAll(dir, 0777); err != nil {
 					l.Error().Msgf("error when creating output folder: %v", err)
+					stats.addFileError(outputDirFileError)
+					continue
+				}
+				dirs[dir] = true
+			}
+			existing, hash := "", ""
+			if idx != nil {
+				var err error
+				if existing, hash, err = idx.find(ma.from); err != nil {
+					l.Error().Msgf("error when looking for duplicates: %v", err)
+					stats.addFileError(duplicateFileError)
+					continue
+				}
+				if existing != "" && dd.handleDuplicate(l, outputFolder, quarantineID, ma, existing, target, mode, stats) {
 					continue
 				}
-				dirs[ma.to] = true
 			}
-			_, f := filepath.Split(ma.from)
-			to := filepath.Join(outputFolder, ma.to, f)
-			l.Debug().Msgf("Moving to %v", to)
-			if err := move(ma.from, to); err != nil {
-				l.Error().Msgf("error when moving %v: %v", to, err)
+			to, col, err := resolveCollision(dd.collisionPolicy, ma.from, target)
+			if err != nil {
+				l.Error().Msgf("error when checking collision: %v", err)
+				stats.addFileError(collisionFileError)
+				continue
+			}
+			if col != nil {
+				l.Warn().Msgf("%v already exists, %v", target, col.Resolution)
+				collisions = append(collisions, *col)
+				stats.update(func(r *Report) { r.Collisions = append(r.Collisions, *col) })
+				if col.Resolution == resolutionDropped && mode == TransferMove {
+					if err := os.Remove(ma.from); err != nil {
+						l.Error().Msgf("error when removing duplicate: %v", err)
+						stats.addFileError(duplicateFileError)
+					} else {
+						dd.recordInJournal(l, JournalDrop, ma.from, col.To, stats)
+					}
+				}
+			}
+			if to == "" {
+				continue
+			}
+			if existing != "" && dd.linkDuplicate(l, ma, existing, to, mode, stats) {
+				moveCount++
+				dd.checkpointDone(l, cp, ma)
+				continue
+			}
+			l.Debug().Msgf("Transferring (%v) to %v", mode, to)
+			var size int64
+			if info, err := os.Stat(ma.from); err == nil {
+				size = info.Size()
+			}
+			if err := transferModes[mode](ma.from, to); err != nil {
+				l.Error().Msgf("error when transferring (%v) %v: %v", mode, to, err)
+				stats.addFileError(transferFileError)
 			} else {
 				moveCount++
+				dd.recordInJournal(l, mode, ma.from, to, stats)
+				dd.checkpointDone(l, cp, ma)
+				if idx != nil {
+					idx.add(to, size, hash)
+				}
+				stats.update(func(r *Report) {
+					r.FilesTransferred++
+					r.BytesTransferred += size
+					// renamed by the rename pattern, collision suffixes excepted
+					if name != ma.to {
+						r.Renames = append(r.Renames, Rename{From: ma.from, To: to})
+					}
+				})
 			}
 		}
 	}
-	log.Info().Msgf("%v moved file(s)", moveCount)
+	log.Info().Msgf("%v transferred file(s) (%v)", moveCount, dd.transferMode)
+	if len(collisions) > 0 {
+		log.Info().Msgf("%v collision(s)", len(collisions))
+		for _, c := range collisions {
+			log.Info().Str(fileLogField, c.From).Msgf("collision with %v: %v", c.To, c.Resolution)
+		}
+	}
 }
 
-func copy(from, to string) error {
-	source, err := os.Open(from)
-	if err != nil {
-		return err
+// sortedActions returns the actions of actionChan sorted by source file once they have all
+// been received, so that files are renamed the same way whatever the order the workers
+// resolved them in
+func sortedActions(actionChan chan moveAction) chan moveAction {
+	actions := []moveAction{}
+	for ma := range actionChan {
+		actions = append(actions, ma)
 	}
-	defer source.Close()
-	destination, err := os.Create(to)
-	if err != nil {
-		return err
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].from < actions[j].from
+	})
+	sorted := make(chan moveAction, len(actions))
+	for _, ma := range actions {
+		sorted <- ma
+	}
+	close(sorted)
+	return sorted
+}
+
+func (dd *DateDispatcher) recordInJournal(l zerolog.Logger, op, from, to string, stats *dispatchStats) {
+	if dd.journal == nil {
+		return
+	}
+	if err := dd.journal.record(op, from, to); err != nil {
+		l.Error().Msgf("error when recording %v in journal: %v", op, err)
+		stats.addFileError(journalFileError)
+	}
+}
+
+func (dd *DateDispatcher) checkpoint(l zerolog.Logger, cp *checkpoint, ma moveAction) {
+	if err := cp.record(ma); err != nil {
+		l.Warn().Str(fileLogField, ma.from).Msgf("error while checkpointing: %v", err)
 	}
-	defer destination.Close()
-	_, err = io.Copy(destination, source)
-	return err
 }
 
-func move(from, to string) error {
-	if err := copy(from, to); err != nil {
-		return err
+func (dd *DateDispatcher) checkpointDone(l zerolog.Logger, cp *checkpoint, ma moveAction) {
+	if err := cp.done(ma.from); err != nil {
+		l.Warn().Str(fileLogField, ma.from).Msgf("error while checkpointing: %v", err)
 	}
-	return os.Remove(from)
 }
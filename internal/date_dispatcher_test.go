@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -21,6 +22,22 @@ func buildDefaultDateDispatcher(t *testing.T, threadCount int) *DateDispatcher {
 	return c
 }
 
+// buildFakeDateDispatcher is buildDefaultDateDispatcher extracting metadata with an in-memory
+// extractor, that gives the date of the testdata pictures to files
+func buildFakeDateDispatcher(t *testing.T, threadCount int, files ...string) (*DateDispatcher, *FakeExtractor) {
+	fake := NewFakeExtractor()
+	for _, f := range files {
+		fake.SetFields(f, map[string]interface{}{"CreateDate": "2019:04:04 13:18:03"})
+	}
+	c, err := NewDateDispatcher(
+		OptThreadCount(threadCount),
+		OptDateFields(map[string]string{"CreateDate": "2006:01:02 15:04:05"}),
+		OptExtractorFactory(fake.Factory()),
+	)
+	assert.Nil(t, err)
+	return c, fake
+}
+
 func TestListFiles(t *testing.T) {
 	var tcs = []struct {
 		tcID        string
@@ -49,7 +66,7 @@ func TestListFiles(t *testing.T) {
 			filesChan := make(chan string, 10)
 
 			c := buildDefaultDateDispatcher(t, 1)
-			c.listFiles(ctx, cancel, tc.folder, filesChan)
+			c.listFiles(ctx, cancel, tc.folder, "", filesChan, newDispatchStats())
 
 			files := make([]string, 10)
 			for f := range filesChan {
@@ -82,9 +99,9 @@ func TestGetMoveActions(t *testing.T) {
 				"../testdata/input/subFolder/20190404_131806.jpg",
 			},
 			expActions: []moveAction{
-				{from: "../testdata/input/20190404_131804.jpg", to: "2019_04"},
-				{from: "../testdata/input/subFolder/20190404_131805.jpg", to: "2019_04"},
-				{from: "../testdata/input/subFolder/20190404_131806.jpg", to: "2019_04"},
+				{from: "../testdata/input/20190404_131804.jpg", to: filepath.Join("2019_04", "20190404_131804.jpg"), source: "CreateDate", date: time.Date(2019, time.April, 4, 13, 18, 3, 0, time.UTC)},
+				{from: "../testdata/input/subFolder/20190404_131805.jpg", to: filepath.Join("2019_04", "20190404_131805.jpg"), source: "CreateDate", date: time.Date(2019, time.April, 4, 13, 18, 3, 0, time.UTC)},
+				{from: "../testdata/input/subFolder/20190404_131806.jpg", to: filepath.Join("2019_04", "20190404_131806.jpg"), source: "CreateDate", date: time.Date(2019, time.April, 4, 13, 18, 3, 0, time.UTC)},
 			},
 		},
 	}
@@ -101,8 +118,8 @@ func TestGetMoveActions(t *testing.T) {
 				}
 				close(fileChan)
 
-				c := buildDefaultDateDispatcher(t, 2)
-				c.getMoveActions(ctx, cancel, fileChan, actionChan)
+				c, _ := buildFakeDateDispatcher(t, 2, tc.files...)
+				c.getMoveActions(ctx, cancel, fileChan, actionChan, nil, nil, newDispatchStats())
 
 				actions := []moveAction{}
 				for ma := range actionChan {
@@ -122,7 +139,7 @@ func TestGuessDateNominal(t *testing.T) {
 	}
 	fm := exiftool.FileMetadata{File: "a", Fields: fields}
 	c := buildDefaultDateDispatcher(t, 2)
-	got, err := c.guessDate(fm)
+	got, _, err := c.guessDate(fm)
 	assert.Nil(t, err)
 	assert.Equal(t, 2018, got.Year())
 	assert.Equal(t, time.January, got.Month())
@@ -138,7 +155,7 @@ func TestGuessDateWithoutDateField(t *testing.T) {
 	}
 	fm := exiftool.FileMetadata{File: "a", Fields: fields}
 	c := buildDefaultDateDispatcher(t, 2)
-	_, err := c.guessDate(fm)
+	_, _, err := c.guessDate(fm)
 	assert.Equal(t, errNoDateFound, err)
 }
 
@@ -149,11 +166,329 @@ func TestGuessDateUnparsableDate(t *testing.T) {
 	}
 	fm := exiftool.FileMetadata{File: "a", Fields: fields}
 	c := buildDefaultDateDispatcher(t, 2)
-	_, err := c.guessDate(fm)
+	_, _, err := c.guessDate(fm)
 	assert.NotNil(t, err)
 	assert.NotEqual(t, errNoDateFound, err)
 }
 
+func TestGuessDatePriority(t *testing.T) {
+	fields := map[string]interface{}{
+		"CreateDate":        "2018:01:02 03:04:05",
+		"Media Create Date": "2019:04:04 13:18:04",
+	}
+	fm := exiftool.FileMetadata{File: "a", Fields: fields}
+	layout := "2006:01:02 15:04:05"
+
+	var tcs = []struct {
+		tcID    string
+		fields  []DateField
+		expYear int
+	}{
+		{"createDateFirst", []DateField{{Field: "CreateDate", Pattern: layout}, {Field: "Media Create Date", Pattern: layout}}, 2018},
+		{"mediaCreateDateFirst", []DateField{{Field: "Media Create Date", Pattern: layout}, {Field: "CreateDate", Pattern: layout}}, 2019},
+		{"firstMissing", []DateField{{Field: "Missing", Pattern: layout}, {Field: "Media Create Date", Pattern: layout}, {Field: "CreateDate", Pattern: layout}}, 2019},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			c, err := NewDateDispatcher(OptOrderedDateFields(tc.fields))
+			assert.Nil(t, err)
+			for i := 0; i < 50; i++ {
+				got, _, err := c.guessDate(fm)
+				assert.Nil(t, err)
+				assert.Equal(t, tc.expYear, got.Year())
+			}
+		})
+	}
+}
+
+func TestGuessDateZones(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	assert.Nil(t, err)
+	layout := "2006:01:02 15:04:05"
+	fields := map[string]interface{}{
+		"CreateDate":         "2019:04:30 22:30:00",
+		"DateTimeOriginal":   "2019:05:01 00:30:00",
+		"OffsetTimeOriginal": "+02:00",
+		"ZonedDate":          "2019:05:01 00:30:00+02:00",
+		"BadOffset":          "+2h",
+	}
+
+	var tcs = []struct {
+		tcID       string
+		field      DateField
+		outputZone string
+		expDate    time.Time
+	}{
+		{"default", DateField{Field: "CreateDate", Pattern: layout}, "", time.Date(2019, time.April, 30, 22, 30, 0, 0, time.UTC)},
+		{"fixedZone", DateField{Field: "DateTimeOriginal", Pattern: layout, Zone: "Europe/Paris"}, "", time.Date(2019, time.May, 1, 0, 30, 0, 0, paris)},
+		{"fixedZoneToUTC", DateField{Field: "DateTimeOriginal", Pattern: layout, Zone: "Europe/Paris"}, "UTC", time.Date(2019, time.April, 30, 22, 30, 0, 0, time.UTC)},
+		{"utcToOutputZone", DateField{Field: "CreateDate", Pattern: layout, Zone: "UTC"}, "Europe/Paris", time.Date(2019, time.May, 1, 0, 30, 0, 0, paris)},
+		{"naiveInOutputZone", DateField{Field: "DateTimeOriginal", Pattern: layout}, "Europe/Paris", time.Date(2019, time.May, 1, 0, 30, 0, 0, paris)},
+		{"offsetField", DateField{Field: "DateTimeOriginal", Pattern: layout, OffsetField: "OffsetTimeOriginal"}, "UTC", time.Date(2019, time.April, 30, 22, 30, 0, 0, time.UTC)},
+		{"missingOffsetField", DateField{Field: "DateTimeOriginal", Pattern: layout, Zone: "UTC", OffsetField: "Missing"}, "", time.Date(2019, time.May, 1, 0, 30, 0, 0, time.UTC)},
+		{"unparsableOffsetField", DateField{Field: "DateTimeOriginal", Pattern: layout, Zone: "UTC", OffsetField: "BadOffset"}, "", time.Date(2019, time.May, 1, 0, 30, 0, 0, time.UTC)},
+		{"zoneInValue", DateField{Field: "ZonedDate", Pattern: layout + "-07:00", Zone: "UTC"}, "UTC", time.Date(2019, time.April, 30, 22, 30, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			opts := []func(*DateDispatcher) error{OptOrderedDateFields([]DateField{tc.field})}
+			if tc.outputZone != "" {
+				opts = append(opts, OptOutputZone(tc.outputZone))
+			}
+			c, err := NewDateDispatcher(opts...)
+			assert.Nil(t, err)
+			got, _, err := c.guessDate(exiftool.FileMetadata{File: "a.jpg", Fields: fields})
+			assert.Nil(t, err)
+			assert.True(t, tc.expDate.Equal(got), "expected %v, got %v", tc.expDate, got)
+			assert.Equal(t, tc.expDate.Format("2006_01_02 15:04"), got.Format("2006_01_02 15:04"))
+		})
+	}
+}
+
+func TestGuessDateFromFileNameInOutputZone(t *testing.T) {
+	c, err := NewDateDispatcher(
+		OptFileNamePatterns([]FileNamePattern{{Regex: `^(\d{8}_\d{6})`, Pattern: "20060102_150405"}}),
+		OptOutputZone("Europe/Paris"),
+	)
+	assert.Nil(t, err)
+	got, _, err := c.guessDate(exiftool.FileMetadata{File: "20190501_003000.jpg", Fields: map[string]interface{}{}})
+	assert.Nil(t, err)
+	assert.Equal(t, "2019_05_01 00:30", got.Format("2006_01_02 15:04"))
+}
+
+func TestUnknownZones(t *testing.T) {
+	_, err := NewDateDispatcher(OptOrderedDateFields([]DateField{{Field: "CreateDate", Pattern: "2006", Zone: "Mars/Olympus"}}))
+	assert.NotNil(t, err)
+	_, err = NewDateDispatcher(OptOutputZone("Mars/Olympus"))
+	assert.NotNil(t, err)
+}
+
+func TestGuessDateRobustness(t *testing.T) {
+	layout := "2006:01:02 15:04:05"
+	fields := []DateField{
+		{Field: "DateTimeOriginal", Pattern: layout},
+		{Field: "CreateDate", Pattern: layout, Patterns: []string{"2006-01-02T15:04:05", "2006"}},
+	}
+	var tcs = []struct {
+		tcID      string
+		fields    map[string]interface{}
+		expErr    bool
+		expSource string
+		expDate   time.Time
+	}{
+		{"nominal", map[string]interface{}{"DateTimeOriginal": "2019:04:04 13:18:04"}, false, "DateTimeOriginal", time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)},
+		{"secondLayout", map[string]interface{}{"CreateDate": "2019-04-04T13:18:04"}, false, "CreateDate", time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)},
+		{"number", map[string]interface{}{"CreateDate": float64(2019)}, false, "CreateDate", time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"list", map[string]interface{}{"DateTimeOriginal": []interface{}{"0000:00:00 00:00:00", "2019:04:04 13:18:04"}}, false, "DateTimeOriginal", time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)},
+		{"sentinelFallsThrough", map[string]interface{}{"DateTimeOriginal": "0000:00:00 00:00:00", "CreateDate": "2019-04-04T13:18:04"}, false, "CreateDate", time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)},
+		{"unparsableFallsThrough", map[string]interface{}{"DateTimeOriginal": "yesterday", "CreateDate": "2019-04-04T13:18:04"}, false, "CreateDate", time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)},
+		{"unsupportedFallsThrough", map[string]interface{}{"DateTimeOriginal": true, "CreateDate": "2019-04-04T13:18:04"}, false, "CreateDate", time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)},
+		{"unparsableOnly", map[string]interface{}{"DateTimeOriginal": "yesterday"}, true, "", time.Time{}},
+		{"unsupportedOnly", map[string]interface{}{"DateTimeOriginal": map[string]interface{}{}}, true, "", time.Time{}},
+	}
+
+	c, err := NewDateDispatcher(OptOrderedDateFields(fields))
+	assert.Nil(t, err)
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			got, src, err := c.guessDate(exiftool.FileMetadata{File: "a.jpg", Fields: tc.fields})
+			if tc.expErr {
+				assert.NotNil(t, err)
+				assert.NotEqual(t, errNoDateFound, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expSource, src)
+			assert.Equal(t, tc.expDate, got)
+		})
+	}
+}
+
+func TestGuessDateSentinelOnly(t *testing.T) {
+	c, err := NewDateDispatcher(OptOrderedDateFields([]DateField{{Field: "CreateDate", Pattern: "2006:01:02 15:04:05"}}))
+	assert.Nil(t, err)
+	_, _, err = c.guessDate(exiftool.FileMetadata{File: "a.jpg", Fields: map[string]interface{}{"CreateDate": "0000:00:00 00:00:00"}})
+	assert.Equal(t, errNoDateFound, err)
+}
+
+func TestOptOrderedDateFieldsNoPattern(t *testing.T) {
+	_, err := NewDateDispatcher(OptOrderedDateFields([]DateField{{Field: "CreateDate"}}))
+	assert.NotNil(t, err)
+}
+
+func TestGuessDatePlausibility(t *testing.T) {
+	layout := "2006:01:02 15:04:05"
+	fields := []DateField{
+		{Field: "DateTimeOriginal", Pattern: layout},
+		{Field: "CreateDate", Pattern: layout},
+	}
+	patterns := []FileNamePattern{{Regex: `^(\d{8}_\d{6})`, Pattern: "20060102_150405"}}
+	min := time.Date(2000, time.January, 2, 0, 0, 0, 0, time.UTC)
+	future := time.Now().AddDate(1, 0, 0).Format(layout)
+
+	var tcs = []struct {
+		tcID        string
+		file        string
+		fields      map[string]interface{}
+		max         time.Time
+		expErr      bool
+		expSource   string
+		expRejected int
+	}{
+		{"plausible", "a.jpg", map[string]interface{}{"DateTimeOriginal": "2019:04:04 13:18:04"}, time.Time{}, false, "DateTimeOriginal", 0},
+		{"tooOld", "a.jpg", map[string]interface{}{"DateTimeOriginal": "1970:01:01 00:00:00", "CreateDate": "2019:04:04 13:18:04"}, time.Time{}, false, "CreateDate", 1},
+		{"deadBattery", "a.jpg", map[string]interface{}{"DateTimeOriginal": "2000:01:01 00:00:00", "CreateDate": "2019:04:04 13:18:04"}, time.Time{}, false, "CreateDate", 1},
+		{"future", "a.jpg", map[string]interface{}{"DateTimeOriginal": future, "CreateDate": "2019:04:04 13:18:04"}, time.Time{}, false, "CreateDate", 1},
+		{"tooRecent", "a.jpg", map[string]interface{}{"DateTimeOriginal": "2019:04:04 13:18:04"}, time.Date(2010, time.January, 1, 0, 0, 0, 0, time.UTC), true, "", 1},
+		{"fileNameFallback", "20190404_131804.jpg", map[string]interface{}{"DateTimeOriginal": "1970:01:01 00:00:00"}, time.Time{}, false, fileNameDateSource, 1},
+		{"allImplausible", "19700101_000000.jpg", map[string]interface{}{"DateTimeOriginal": "1970:01:01 00:00:00", "CreateDate": "2000:01:01 00:00:00"}, time.Time{}, true, "", 3},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			c, err := NewDateDispatcher(
+				OptOrderedDateFields(fields),
+				OptFileNamePatterns(patterns),
+				OptDateWindow(min, tc.max),
+				OptRejectFutureDates(),
+			)
+			assert.Nil(t, err)
+			_, src, rejected, err := c.guessDateWithRejections(exiftool.FileMetadata{File: tc.file, Fields: tc.fields}, c.dateFields)
+			assert.Equal(t, tc.expRejected, rejected)
+			if tc.expErr {
+				assert.Equal(t, errNoDateFound, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expSource, src)
+		})
+	}
+}
+
+func TestOptDateWindowInvalid(t *testing.T) {
+	_, err := NewDateDispatcher(OptDateWindow(time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC), time.Date(2010, time.January, 1, 0, 0, 0, 0, time.UTC)))
+	assert.NotNil(t, err)
+}
+
+func TestOptDateFieldsIsStable(t *testing.T) {
+	fields := map[string]string{"b": "p2", "c": "p3", "a": "p1"}
+	exp := []DateField{{Field: "a", Pattern: "p1"}, {Field: "b", Pattern: "p2"}, {Field: "c", Pattern: "p3"}}
+	for i := 0; i < 20; i++ {
+		c, err := NewDateDispatcher(OptDateFields(fields))
+		assert.Nil(t, err)
+		assert.Equal(t, exp, c.dateFields)
+	}
+}
+
+func TestOptOrderedDateFieldsEmptyName(t *testing.T) {
+	_, err := NewDateDispatcher(OptOrderedDateFields([]DateField{{Field: "", Pattern: "2006"}}))
+	assert.NotNil(t, err)
+}
+
+func TestGuessDateFromFileName(t *testing.T) {
+	patterns := []FileNamePattern{
+		{Regex: `^(\d{8}_\d{6})`, Pattern: "20060102_150405"},
+		{Regex: `^IMG-(\d{8})-WA\d+`, Pattern: "20060102"},
+		{Regex: `^\d{4}-\d{2}`, Pattern: "2006-01"},
+	}
+	var tcs = []struct {
+		tcID     string
+		file     string
+		fields   map[string]interface{}
+		expFound bool
+		expDate  time.Time
+	}{
+		{"timestamp", "/a/20190404_131804.jpg", map[string]interface{}{}, true, time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)},
+		{"whatsapp", "IMG-20200101-WA0001.jpg", map[string]interface{}{}, true, time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"wholeMatch", "2021-03 scan.png", map[string]interface{}{}, true, time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)},
+		{"unparsable", "20191304_131804.jpg", map[string]interface{}{}, false, time.Time{}},
+		{"noMatch", "DSC_0042.JPG", map[string]interface{}{}, false, time.Time{}},
+		{"exifFirst", "20190404_131804.jpg", map[string]interface{}{"CreateDate": "2018:01:02 03:04:05"}, true, time.Date(2018, time.January, 2, 3, 4, 5, 0, time.UTC)},
+	}
+
+	c, err := NewDateDispatcher(
+		OptDateFields(map[string]string{"CreateDate": "2006:01:02 15:04:05"}),
+		OptFileNamePatterns(patterns),
+	)
+	assert.Nil(t, err)
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			got, _, err := c.guessDate(exiftool.FileMetadata{File: tc.file, Fields: tc.fields})
+			if tc.expFound {
+				assert.Nil(t, err)
+				assert.Equal(t, tc.expDate, got)
+			} else {
+				assert.Equal(t, errNoDateFound, err)
+			}
+		})
+	}
+}
+
+func TestOptFileNamePatternsInvalidRegex(t *testing.T) {
+	_, err := NewDateDispatcher(OptFileNamePatterns([]FileNamePattern{{Regex: "(", Pattern: "2006"}}))
+	assert.NotNil(t, err)
+}
+
+func TestGuessDateSource(t *testing.T) {
+	c, err := NewDateDispatcher(
+		OptOrderedDateFields([]DateField{{Field: "CreateDate", Pattern: "2006:01:02 15:04:05"}}),
+		OptFileNamePatterns([]FileNamePattern{{Regex: `^(\d{8}_\d{6})`, Pattern: "20060102_150405"}}),
+	)
+	assert.Nil(t, err)
+
+	_, src, err := c.guessDate(exiftool.FileMetadata{File: "20190404_131804.jpg", Fields: map[string]interface{}{"CreateDate": "2018:01:02 03:04:05"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "CreateDate", src)
+
+	_, src, err = c.guessDate(exiftool.FileMetadata{File: "20190404_131804.jpg", Fields: map[string]interface{}{}})
+	assert.Nil(t, err)
+	assert.Equal(t, fileNameDateSource, src)
+}
+
+func TestGuessDateFromFileTime(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "noDate.txt")
+	assert.Nil(t, copy("../testdata/input/subFolder/noDate.txt", file))
+	mtime := time.Date(2017, time.June, 5, 10, 11, 12, 0, time.Local)
+	assert.Nil(t, os.Chtimes(file, mtime, mtime))
+	fm := exiftool.FileMetadata{File: file, Fields: map[string]interface{}{}}
+
+	var tcs = []struct {
+		tcID     string
+		fallback string
+		expMTime bool
+	}{
+		{"mtime", FileTimeModification, true},
+		{"ctime", FileTimeChange, false},
+		{"birthtime", FileTimeBirth, false},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			c, err := NewDateDispatcher(OptFileTimeFallback(tc.fallback))
+			assert.Nil(t, err)
+			got, src, err := c.guessDate(fm)
+			assert.Nil(t, err)
+			assert.True(t, isFileTimeSource(src))
+			if tc.expMTime || src == FileTimeModification {
+				assert.True(t, mtime.Equal(got))
+			}
+		})
+	}
+}
+
+func TestGuessDateWithoutFileTimeFallback(t *testing.T) {
+	c := buildDefaultDateDispatcher(t, 1)
+	_, _, err := c.guessDate(exiftool.FileMetadata{File: "../testdata/input/subFolder/noDate.txt", Fields: map[string]interface{}{}})
+	assert.Equal(t, errNoDateFound, err)
+}
+
+func TestOptFileTimeFallbackUnsupported(t *testing.T) {
+	_, err := NewDateDispatcher(OptFileTimeFallback("atime"))
+	assert.NotNil(t, err)
+}
+
 func checkExist(t *testing.T, path string, shouldExist bool) {
 	_, err := os.Stat(path)
 	if shouldExist {
@@ -174,16 +509,132 @@ func TestMoveFiles(t *testing.T) {
 
 	ctx, cancel := context.WithCancel(context.TODO())
 	moveChan := make(chan moveAction, 2)
-	moveChan <- moveAction{from: inFile, to: "2019_04"}
+	moveChan <- moveAction{from: inFile, to: filepath.Join("2019_04", "20190404_131804.jpg")}
 	close(moveChan)
 
 	c := buildDefaultDateDispatcher(t, 2)
-	c.moveFiles(ctx, cancel, outDir, moveChan)
+	c.moveFiles(ctx, cancel, outDir, moveChan, nil, newDispatchStats())
 
 	checkExist(t, inFile, false)
 	checkExist(t, filepath.Join(outDir, "2019_04", "20190404_131804.jpg"), true)
 }
 
+func TestMoveFilesCollision(t *testing.T) {
+	tmpDir := t.TempDir()
+	inDir := filepath.Join(tmpDir, "in")
+	assert.Nil(t, os.MkdirAll(filepath.Join(inDir, "a"), 0777))
+	assert.Nil(t, os.MkdirAll(filepath.Join(inDir, "b"), 0777))
+	outDir := filepath.Join(tmpDir, "out")
+	inFile1 := filepath.Join(inDir, "a", "20190404_131804.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", inFile1))
+	inFile2 := filepath.Join(inDir, "b", "20190404_131804.jpg")
+	assert.Nil(t, copy("../testdata/input/subFolder/noDate.txt", inFile2))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	moveChan := make(chan moveAction, 2)
+	moveChan <- moveAction{from: inFile1, to: filepath.Join("2019_04", "20190404_131804.jpg")}
+	moveChan <- moveAction{from: inFile2, to: filepath.Join("2019_04", "20190404_131804.jpg")}
+	close(moveChan)
+
+	c := buildDefaultDateDispatcher(t, 2)
+	stats := newDispatchStats()
+	c.moveFiles(ctx, cancel, outDir, moveChan, nil, stats)
+
+	checkExist(t, inFile1, false)
+	checkExist(t, inFile2, false)
+	checkExist(t, filepath.Join(outDir, "2019_04", "20190404_131804.jpg"), true)
+	checkExist(t, filepath.Join(outDir, "2019_04", "20190404_131804_1.jpg"), true)
+	r := stats.buildReport()
+	assert.Equal(t, 2, r.FilesTransferred)
+	assert.Len(t, r.Collisions, 1)
+	assert.Equal(t, resolutionRenamed, r.Collisions[0].Resolution)
+}
+
+func TestMoveFilesRename(t *testing.T) {
+	tmpDir := t.TempDir()
+	outDir := filepath.Join(tmpDir, "out")
+	inFile1 := filepath.Join(tmpDir, "DSC_0042.JPG")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", inFile1))
+	inFile2 := filepath.Join(tmpDir, "DSC_0043.JPG")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", inFile2))
+	d := time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	moveChan := make(chan moveAction, 2)
+	moveChan <- moveAction{from: inFile1, to: filepath.Join("2019_04", "DSC_0042.JPG"), date: d}
+	moveChan <- moveAction{from: inFile2, to: filepath.Join("2019_04", "DSC_0043.JPG"), date: d}
+	close(moveChan)
+
+	c, err := NewDateDispatcher(OptRenamePattern("20060102_150405"))
+	assert.Nil(t, err)
+	stats := newDispatchStats()
+	c.moveFiles(ctx, cancel, outDir, moveChan, nil, stats)
+
+	checkExist(t, filepath.Join(outDir, "2019_04", "20190404_131804.JPG"), true)
+	checkExist(t, filepath.Join(outDir, "2019_04", "20190404_131804_1.JPG"), true)
+	r := stats.buildReport()
+	assert.Equal(t, 2, r.FilesTransferred)
+	assert.Len(t, r.Collisions, 0)
+	assert.ElementsMatch(t, []Rename{
+		{From: inFile1, To: filepath.Join(outDir, "2019_04", "20190404_131804.JPG")},
+		{From: inFile2, To: filepath.Join(outDir, "2019_04", "20190404_131804_1.JPG")},
+	}, r.Renames)
+}
+
+func TestMoveFilesRenameOrder(t *testing.T) {
+	tmpDir := t.TempDir()
+	outDir := filepath.Join(tmpDir, "out")
+	inFile1 := filepath.Join(tmpDir, "DSC_0042.JPG")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", inFile1))
+	inFile2 := filepath.Join(tmpDir, "DSC_0043.JPG")
+	assert.Nil(t, copy("../testdata/input/subFolder/noDate.txt", inFile2))
+	d := time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)
+
+	// the burst counter follows the source files order, not the order they are received in
+	ctx, cancel := context.WithCancel(context.TODO())
+	moveChan := make(chan moveAction, 2)
+	moveChan <- moveAction{from: inFile2, to: filepath.Join("2019_04", "DSC_0043.JPG"), date: d}
+	moveChan <- moveAction{from: inFile1, to: filepath.Join("2019_04", "DSC_0042.JPG"), date: d}
+	close(moveChan)
+
+	c, err := NewDateDispatcher(OptRenamePattern("20060102_150405"), OptTransferMode(TransferCopy))
+	assert.Nil(t, err)
+	c.moveFiles(ctx, cancel, outDir, moveChan, nil, newDispatchStats())
+
+	checkSameFile := func(exp string, actual string) {
+		same, err := sameContent(exp, actual)
+		assert.Nil(t, err)
+		assert.True(t, same)
+	}
+	checkSameFile(inFile1, filepath.Join(outDir, "2019_04", "20190404_131804.JPG"))
+	checkSameFile(inFile2, filepath.Join(outDir, "2019_04", "20190404_131804_1.JPG"))
+}
+
+func TestMoveFilesRenameCollisionNotReported(t *testing.T) {
+	tmpDir := t.TempDir()
+	outDir := filepath.Join(tmpDir, "out")
+	assert.Nil(t, os.MkdirAll(filepath.Join(outDir, "2019_04"), 0777))
+	assert.Nil(t, os.WriteFile(filepath.Join(outDir, "2019_04", "20190404_131804.JPG"), []byte("existing"), 0666))
+	inFile := filepath.Join(tmpDir, "20190404_131804.JPG")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", inFile))
+	d := time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	moveChan := make(chan moveAction, 1)
+	moveChan <- moveAction{from: inFile, to: filepath.Join("2019_04", "20190404_131804.JPG"), date: d}
+	close(moveChan)
+
+	c, err := NewDateDispatcher(OptRenamePattern("20060102_150405"))
+	assert.Nil(t, err)
+	stats := newDispatchStats()
+	c.moveFiles(ctx, cancel, outDir, moveChan, nil, stats)
+
+	checkExist(t, filepath.Join(outDir, "2019_04", "20190404_131804_1.JPG"), true)
+	r := stats.buildReport()
+	assert.Len(t, r.Collisions, 1)
+	assert.Empty(t, r.Renames)
+}
+
 func TestDispatch(t *testing.T) {
 	zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	tmpDir := t.TempDir() + "TestClassify"
@@ -198,8 +649,21 @@ func TestDispatch(t *testing.T) {
 
 	outDir := filepath.Join(tmpDir, "out")
 	os.MkdirAll(outDir, 0777)
-	c := buildDefaultDateDispatcher(t, 2)
-	c.Dispatch(inDir, outDir)
+	c, _ := buildFakeDateDispatcher(t, 2,
+		filepath.Join(subDir, "20190404_131805.jpg"),
+		filepath.Join(subDir, "20190404_131806.jpg"),
+		filepath.Join(inDir, "20190404_131804.jpg"),
+	)
+	report, err := c.Dispatch(inDir, outDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, report.FilesScanned)
+	assert.Equal(t, 3, report.FilesTransferred)
+	assert.Equal(t, 1, report.SkippedNoDate)
+	assert.Equal(t, 0, report.MetadataErrors)
+	assert.Empty(t, report.Collisions)
+	info, err := os.Stat("../testdata/input/20190404_131804.jpg")
+	assert.Nil(t, err)
+	assert.Equal(t, 3*info.Size(), report.BytesTransferred)
 
 	checkExist(t, filepath.Join(subDir, "noDate.txt"), true)
 	checkExist(t, filepath.Join(subDir, "20190404_131805.jpg"), false)
@@ -209,3 +673,190 @@ func TestDispatch(t *testing.T) {
 	checkExist(t, filepath.Join(outDir, "2019_04", "20190404_131805.jpg"), true)
 	checkExist(t, filepath.Join(outDir, "2019_04", "20190404_131806.jpg"), true)
 }
+
+func TestDispatchResume(t *testing.T) {
+	tmpDir := t.TempDir()
+	inDir := filepath.Join(tmpDir, "in")
+	assert.Nil(t, os.MkdirAll(inDir, 0777))
+	outDir := filepath.Join(tmpDir, "out")
+	resolved := filepath.Join(inDir, "resolved.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", resolved))
+	notResolved := filepath.Join(inDir, "20190404_131804.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", notResolved))
+
+	c, fake := buildFakeDateDispatcher(t, 2, notResolved)
+	// state left by an interrupted dispatch
+	cp, err := openCheckpoint(outDir, c.fingerprint())
+	assert.Nil(t, err)
+	assert.Nil(t, cp.record(moveAction{from: resolved, to: filepath.Join("2001_01", "resolved.jpg"), source: "CreateDate"}))
+	assert.Nil(t, cp.close())
+	assert.Nil(t, os.MkdirAll(filepath.Join(outDir, "2019_04"), 0777))
+	partial := filepath.Join(outDir, "2019_04", "20190404_131804.jpg"+partExt)
+	assert.Nil(t, os.WriteFile(partial, []byte("trunc"), 0666))
+
+	report, err := c.Dispatch(inDir, outDir)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{notResolved}, fake.Extracted())
+	assert.Equal(t, 1, report.ResumedFiles)
+	assert.Equal(t, 1, report.PartialFilesCleaned)
+	checkExist(t, partial, false)
+	checkExist(t, filepath.Join(outDir, "2001_01", "resolved.jpg"), true)
+	checkExist(t, filepath.Join(outDir, "2019_04", "20190404_131804.jpg"), true)
+	checkExist(t, filepath.Join(outDir, stateFolder, checkpointFile), false)
+}
+
+func TestDispatchContextCanceled(t *testing.T) {
+	tmpDir := t.TempDir()
+	inDir := filepath.Join(tmpDir, "in")
+	assert.Nil(t, os.MkdirAll(inDir, 0777))
+	outDir := filepath.Join(tmpDir, "out")
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", filepath.Join(inDir, fmt.Sprintf("%v.jpg", i))))
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	c, _ := buildFakeDateDispatcher(t, 2)
+	report, err := c.DispatchContext(ctx, inDir, outDir)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "interrupted")
+	assert.Equal(t, 0, report.FilesTransferred)
+	for i := 0; i < 10; i++ {
+		checkExist(t, filepath.Join(inDir, fmt.Sprintf("%v.jpg", i)), true)
+	}
+	checkExist(t, filepath.Join(outDir, stateFolder, checkpointFile), true)
+}
+
+func TestDispatchCopyResume(t *testing.T) {
+	tmpDir := t.TempDir()
+	inDir := filepath.Join(tmpDir, "in")
+	assert.Nil(t, os.MkdirAll(inDir, 0777))
+	outDir := filepath.Join(tmpDir, "out")
+	a := filepath.Join(inDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", a))
+	b := filepath.Join(inDir, "b.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", b))
+
+	fake := NewFakeExtractor()
+	fake.SetFields(a, map[string]interface{}{"CreateDate": "2019:04:04 13:18:03"})
+	fake.SetFields(b, map[string]interface{}{"CreateDate": "2019:04:04 13:18:03"})
+	c, err := NewDateDispatcher(
+		OptThreadCount(1),
+		OptBatchSize(1),
+		OptDateFields(map[string]string{"CreateDate": "2006:01:02 15:04:05"}),
+		OptTransferMode(TransferCopy),
+		OptExtractorFactory(fake.Factory()),
+	)
+	assert.Nil(t, err)
+
+	// interrupted once a.jpg has been copied, while the metadata of b.jpg are extracted
+	fake.SetDelay(b, 500*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.TODO())
+	go func() {
+		defer cancel()
+		for i := 0; i < 100; i++ {
+			if _, err := os.Stat(filepath.Join(outDir, "2019_04", "a.jpg")); err == nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	report, err := c.DispatchContext(ctx, inDir, outDir)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, report.FilesTransferred)
+	checkExist(t, filepath.Join(outDir, stateFolder, checkpointFile), true)
+
+	fake.SetDelay(b, 0)
+	report, err = c.Dispatch(inDir, outDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.ResumedFiles)
+	assert.Equal(t, 1, report.FilesTransferred)
+	assert.Empty(t, report.Collisions)
+	checkExist(t, a, true)
+	checkExist(t, b, true)
+	checkExist(t, filepath.Join(outDir, "2019_04", "a.jpg"), true)
+	checkExist(t, filepath.Join(outDir, "2019_04", "b.jpg"), true)
+	checkExist(t, filepath.Join(outDir, "2019_04", "a_1.jpg"), false)
+	checkExist(t, filepath.Join(outDir, stateFolder, checkpointFile), false)
+}
+
+func TestDispatchErrorsRemoveCheckpoint(t *testing.T) {
+	inDir := t.TempDir()
+	outDir := t.TempDir()
+	file := filepath.Join(inDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", file))
+	c, fake := buildFakeDateDispatcher(t, 1)
+	fake.SetError(file, fmt.Errorf("unreadable"))
+
+	_, err := c.Dispatch(inDir, outDir)
+	assert.NotNil(t, err)
+	checkExist(t, filepath.Join(outDir, stateFolder, checkpointFile), false)
+}
+
+func TestDispatchNonExistingFolder(t *testing.T) {
+	c := buildDefaultDateDispatcher(t, 2)
+	_, err := c.Dispatch("../nonExistingFolder", t.TempDir())
+	assert.NotNil(t, err)
+	_, ok := err.(*DispatchError)
+	assert.True(t, ok)
+	assert.Contains(t, err.Error(), "nonExistingFolder")
+}
+
+func TestDispatchMissingExiftool(t *testing.T) {
+	c, err := NewDateDispatcher(
+		OptThreadCount(2),
+		OptExiftoolPath("../nonExistingFolder/exiftool"),
+	)
+	assert.Nil(t, err)
+	inDir := t.TempDir()
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", filepath.Join(inDir, fmt.Sprintf("%v.jpg", i))))
+	}
+
+	_, err = c.Dispatch(inDir, t.TempDir())
+	assert.NotNil(t, err)
+	dErr, ok := err.(*DispatchError)
+	assert.True(t, ok)
+	assert.Len(t, dErr.Errors, 1)
+	checkExist(t, filepath.Join(inDir, "0.jpg"), true)
+}
+
+func TestMoveFilesErrorCount(t *testing.T) {
+	tmpDir := t.TempDir()
+	actionChan := make(chan moveAction, 2)
+	actionChan <- moveAction{from: filepath.Join(tmpDir, "missing1.jpg"), to: filepath.Join("2019_04", "missing1.jpg")}
+	actionChan <- moveAction{from: filepath.Join(tmpDir, "missing2.jpg"), to: filepath.Join("2019_04", "missing2.jpg")}
+	close(actionChan)
+
+	stats := newDispatchStats()
+	ctx, cancel := context.WithCancel(context.TODO())
+	c := buildDefaultDateDispatcher(t, 1)
+	c.moveFiles(ctx, cancel, filepath.Join(tmpDir, "out"), actionChan, nil, stats)
+
+	err := stats.err()
+	assert.NotNil(t, err)
+	assert.Equal(t, map[string]int{transferFileError: 2}, err.(*DispatchError).FileErrors)
+	assert.Equal(t, 0, stats.buildReport().FilesTransferred)
+}
+
+func benchmarkMove(b *testing.B, moveFunc func(from, to string) error) {
+	tmpDir := b.TempDir()
+	from := filepath.Join(tmpDir, "from.bin")
+	to := filepath.Join(tmpDir, "to.bin")
+	assert.Nil(b, os.WriteFile(from, make([]byte, 32*1024*1024), 0666))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := moveFunc(from, to); err != nil {
+			b.Fatal(err)
+		}
+		from, to = to, from
+	}
+}
+
+func BenchmarkMoveRename(b *testing.B) {
+	benchmarkMove(b, move)
+}
+
+func BenchmarkMoveCopy(b *testing.B) {
+	benchmarkMove(b, moveByCopy)
+}
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// layouts returns the layouts a date field is parsed with, by priority
+func (df DateField) layouts() []string {
+	l := make([]string, 0, len(df.Patterns)+1)
+	if df.Pattern != "" {
+		l = append(l, df.Pattern)
+	}
+	return append(l, df.Patterns...)
+}
+
+// dateValues converts a value returned by exiftool to the strings that may hold a date : a
+// string as is, a number formatted without exponent, each element of a list
+func dateValues(val interface{}) []string {
+	switch v := val.(type) {
+	case string:
+		return []string{v}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case int, int64:
+		return []string{fmt.Sprintf("%v", v)}
+	case []interface{}:
+		vals := []string{}
+		for _, e := range v {
+			vals = append(vals, dateValues(e)...)
+		}
+		return vals
+	}
+	return nil
+}
+
+// isSentinelDate returns true for the values cameras write when the date is unknown, such
+// as "0000:00:00 00:00:00" or "    :  :     :  :  "
+func isSentinelDate(val string) bool {
+	return strings.Trim(val, "0:-./ TZ") == ""
+}
@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDateValues(t *testing.T) {
+	var tcs = []struct {
+		tcID    string
+		val     interface{}
+		expVals []string
+	}{
+		{"string", "2019:04:04 13:18:04", []string{"2019:04:04 13:18:04"}},
+		{"float", float64(2019), []string{"2019"}},
+		{"bigFloat", float64(1554383884), []string{"1554383884"}},
+		{"int", 2019, []string{"2019"}},
+		{"list", []interface{}{"0000:00:00 00:00:00", float64(2019)}, []string{"0000:00:00 00:00:00", "2019"}},
+		{"bool", true, nil},
+		{"nil", nil, nil},
+		{"map", map[string]interface{}{"a": "b"}, nil},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			assert.Equal(t, tc.expVals, dateValues(tc.val))
+		})
+	}
+}
+
+func TestIsSentinelDate(t *testing.T) {
+	var tcs = []struct {
+		tcID     string
+		val      string
+		expValid bool
+	}{
+		{"zeros", "0000:00:00 00:00:00", false},
+		{"blank", "    :  :     :  :  ", false},
+		{"empty", "", false},
+		{"zerosWithZone", "0000-00-00T00:00:00Z", false},
+		{"date", "2019:04:04 13:18:04", true},
+		{"midnight", "2019:04:04 00:00:00", true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			assert.Equal(t, !tc.expValid, isSentinelDate(tc.val))
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package internal
+
+import (
+	"time"
+)
+
+// futureTolerance is how far in the future a date can be when future dates are rejected,
+// so that dates interpreted in the wrong zone are not rejected
+const futureTolerance = 24 * time.Hour
+
+// plausible returns false if t is outside the plausibility window of the dispatcher
+func (dd *DateDispatcher) plausible(t time.Time) bool {
+	if !dd.minDate.IsZero() && t.Before(dd.minDate) {
+		return false
+	}
+	if !dd.maxDate.IsZero() && t.After(dd.maxDate) {
+		return false
+	}
+	if dd.rejectFutureDates && t.After(time.Now().Add(futureTolerance)) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// noValue is how text/template renders a missing value
+const noValue = "<no value>"
+
+// pathSanitizer replaces the characters of metadata values that can't be part of a folder
+// or file name
+var pathSanitizer = strings.NewReplacer("/", "_", "\\", "_", ":", "_", "*", "_", "?", "_", "\"", "_", "<", "_", ">", "_", "|", "_")
+
+// Camera describes the device that produced a file
+type Camera struct {
+	Make  string
+	Model string
+}
+
+// PathData is the data available to output templates
+type PathData struct {
+	Date      time.Time
+	Year      string
+	Month     string
+	MonthName string
+	Day       string
+	Hour      string
+	Minute    string
+	Second    string
+	// Name is the file name without extension
+	Name string
+	// Ext is the file extension, including the dot
+	Ext    string
+	Source string
+	Camera Camera
+	// Fields contains the metadata extracted from the file
+	Fields map[string]interface{}
+}
+
+func newPathData(file string, d time.Time, source string, fields map[string]interface{}) PathData {
+	base := filepath.Base(file)
+	ext := filepath.Ext(base)
+	sanitized := make(map[string]interface{}, len(fields))
+	for k, v := range fields {
+		if s, ok := v.(string); ok {
+			v = pathSanitizer.Replace(s)
+		}
+		sanitized[k] = v
+	}
+	return PathData{
+		Date:      d,
+		Year:      d.Format("2006"),
+		Month:     d.Format("01"),
+		MonthName: d.Format("January"),
+		Day:       d.Format("02"),
+		Hour:      d.Format("15"),
+		Minute:    d.Format("04"),
+		Second:    d.Format("05"),
+		Name:      strings.TrimSuffix(base, ext),
+		Ext:       ext,
+		Source:    source,
+		Camera: Camera{
+			Make:  stringField(sanitized, "Make"),
+			Model: stringField(sanitized, "Model"),
+		},
+		Fields: sanitized,
+	}
+}
+
+func stringField(fields map[string]interface{}, key string) string {
+	if v, found := fields[key]; found && v != nil {
+		return strings.TrimSpace(fmt.Sprintf("%v", v))
+	}
+	return ""
+}
+
+// parseOutputTemplate parses an output template, that must use the name or the extension
+// of the file since it renders the path of the file and not only its folder. Missing keys
+// are errors, so that misspelled metadata are not silently ignored.
+func parseOutputTemplate(tpl string) (*template.Template, error) {
+	t, err := template.New("output").Option("missingkey=error").Parse(tpl)
+	if err != nil {
+		return nil, err
+	}
+	if !templateUsesFileName(t) {
+		return nil, fmt.Errorf("output template doesn't use the file name (.Name) nor its extension (.Ext): %v", tpl)
+	}
+	return t, nil
+}
+
+// destination returns the path, relative to the output folder, where a file has to be
+// transferred : the rendered output template tpl if any, <date formatted with the output
+// date format>/<file name> otherwise
+func (dd *DateDispatcher) destination(tpl *template.Template, file string, d time.Time, source string, fields map[string]interface{}) (string, error) {
+	if tpl == nil {
+		return filepath.Join(d.Format(dd.outputDateFormat), filepath.Base(file)), nil
+	}
+	var sb strings.Builder
+	if err := tpl.Execute(&sb, newPathData(file, d, source, fields)); err != nil {
+		return "", fmt.Errorf("error while rendering output template: %w", err)
+	}
+	if strings.Contains(sb.String(), noValue) {
+		// index .Fields "X" renders a missing metadata this way
+		return "", fmt.Errorf("output template rendered a missing value for %v: %v", file, sb.String())
+	}
+	rel := filepath.Clean(filepath.FromSlash(strings.TrimSpace(sb.String())))
+	if rel == "." || filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("output template rendered an invalid path for %v: %v", file, sb.String())
+	}
+	return rel, nil
+}
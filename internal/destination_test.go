@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDestination(t *testing.T) {
+	d := time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)
+	fields := map[string]interface{}{"Make": "Canon", "Model": "EOS 5D/Mark IV", "ISO": 100}
+	var tcs = []struct {
+		tcID     string
+		template string
+		expTo    string
+		expErr   bool
+	}{
+		{"default", "", filepath.Join("2019_04", "IMG_0001.JPG"), false},
+		{"nested", "{{.Year}}/{{.Month}}-{{.MonthName}}/{{.Camera.Model}}/{{.Name}}{{.Ext}}", filepath.Join("2019", "04-April", "EOS 5D_Mark IV", "IMG_0001.JPG"), false},
+		{"fields", "{{.Camera.Make}}/{{index .Fields \"ISO\"}}_{{.Source}}{{.Ext}}", filepath.Join("Canon", "100_CreateDate.JPG"), false},
+		{"missingField", "{{.Year}}/{{.Fields.Lens}}/{{.Name}}{{.Ext}}", "", true},
+		{"missingIndexedField", "{{.Year}}/{{index .Fields \"Lens\"}}/{{.Name}}{{.Ext}}", "", true},
+		{"optionalField", "{{.Year}}/{{with index .Fields \"Lens\"}}{{.}}/{{end}}{{.Name}}{{.Ext}}", filepath.Join("2019", "IMG_0001.JPG"), false},
+		{"renamed", "{{.Year}}/{{.Date.Format \"150405\"}}{{.Ext}}", filepath.Join("2019", "131804.JPG"), false},
+		{"parent", "../{{.Name}}{{.Ext}}", "", true},
+		{"absolute", "/{{.Name}}{{.Ext}}", "", true},
+		{"empty", "{{if false}}{{.Name}}{{end}}", "", true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			opts := []func(*DateDispatcher) error{OptDateOutputFormat("2006_01")}
+			if tc.template != "" {
+				opts = append(opts, OptOutputTemplate(tc.template))
+			}
+			dd, err := NewDateDispatcher(opts...)
+			assert.Nil(t, err)
+			to, err := dd.destination(dd.outputTemplate, filepath.Join("in", "IMG_0001.JPG"), d, "CreateDate", fields)
+			if tc.expErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expTo, to)
+		})
+	}
+}
+
+func TestOptOutputTemplateInvalid(t *testing.T) {
+	var tcs = []struct {
+		tcID     string
+		template string
+	}{
+		{"unparsable", "{{.Year"},
+		{"folderOnly", "{{.Year}}/{{.Month}}"},
+		{"folderOnlyWithFields", "{{.Year}}/{{.Fields.Model}}"},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			_, err := NewDateDispatcher(OptOutputTemplate(tc.template))
+			assert.NotNil(t, err)
+		})
+	}
+	_, err := NewDateDispatcher(OptRules([]Rule{{Name: "r", OutputTemplate: "{{.Year}}/{{.Month}}"}}))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	metadataFileError    = "metadata"
+	dateFileError        = "date"
+	collisionFileError   = "collision"
+	outputDirFileError   = "outputFolder"
+	transferFileError    = "transfer"
+	duplicateFileError   = "duplicate"
+	journalFileError     = "journal"
+	destinationFileError = "destination"
+)
+
+// DispatchError aggregates the errors that occurred during a dispatch : the errors that
+// interrupted a stage of the pipeline and the count of files that failed, by kind
+type DispatchError struct {
+	Errors     []error
+	FileErrors map[string]int
+}
+
+func (e *DispatchError) Error() string {
+	msgs := []string{}
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	if len(e.FileErrors) > 0 {
+		kinds := make([]string, 0, len(e.FileErrors))
+		total := 0
+		for k, c := range e.FileErrors {
+			kinds = append(kinds, fmt.Sprintf("%v: %v", k, c))
+			total += c
+		}
+		sort.Strings(kinds)
+		msgs = append(msgs, fmt.Sprintf("%v file error(s) (%v)", total, strings.Join(kinds, ", ")))
+	}
+	return strings.Join(msgs, "; ")
+}
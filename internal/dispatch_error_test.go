@@ -0,0 +1,24 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatchStatsErr(t *testing.T) {
+	s := newDispatchStats()
+	assert.Nil(t, s.err())
+
+	s.addError(nil)
+	assert.Nil(t, s.err())
+
+	s.addError(fmt.Errorf("walk failed"))
+	s.addFileError(transferFileError)
+	s.addFileError(metadataFileError)
+	s.addFileError(transferFileError)
+	err := s.err()
+	assert.NotNil(t, err)
+	assert.Equal(t, "walk failed; 3 file error(s) (metadata: 1, transfer: 2)", err.Error())
+}
@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DuplicateSkip leaves duplicates in the source folder
+	DuplicateSkip = "skip"
+	// DuplicateQuarantine moves duplicates to the quarantine folder
+	DuplicateQuarantine = "quarantine"
+	// DuplicateHardlink replaces duplicates with a hard link to the file already in the
+	// output folder
+	DuplicateHardlink = "hardlink"
+
+	duplicateSkipped     = "skipped"
+	duplicateQuarantined = "quarantined"
+	duplicateHardlinked  = "hardlinked"
+
+	duplicatesFolder = "duplicates"
+)
+
+// OptDuplicatePolicy enables the detection of the files whose content (SHA-256) already
+// exists anywhere in the output folder, whatever their name : DuplicateSkip,
+// DuplicateQuarantine or DuplicateHardlink. Duplicates are dispatched as any other file by
+// default.
+func OptDuplicatePolicy(policy string) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		switch policy {
+		case DuplicateSkip, DuplicateQuarantine, DuplicateHardlink:
+			c.duplicatePolicy = policy
+			return nil
+		default:
+			return fmt.Errorf("unsupported duplicate policy: %v", policy)
+		}
+	}
+}
+
+// duplicateIndex indexes the content of the output folder. Files are hashed lazily : only
+// when a file of the same size is looked up.
+type duplicateIndex struct {
+	pending map[int64][]string
+	hashes  map[string]string
+	sizes   map[int64]bool
+}
+
+func newDuplicateIndex() *duplicateIndex {
+	return &duplicateIndex{
+		pending: make(map[int64][]string),
+		hashes:  make(map[string]string),
+		sizes:   make(map[int64]bool),
+	}
+}
+
+// buildDuplicateIndex indexes the regular files of outputFolder, the state folder and the
+// files left by interrupted copies excepted
+func buildDuplicateIndex(outputFolder string) (*duplicateIndex, error) {
+	idx := newDuplicateIndex()
+	err := filepath.Walk(outputFolder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == outputFolder {
+				return nil
+			}
+			return fmt.Errorf("error when browsing file %v: %v", path, err)
+		}
+		if info.IsDir() && info.Name() == stateFolder {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() && !strings.HasSuffix(path, partExt) {
+			idx.add(path, info.Size(), "")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while indexing %v: %w", outputFolder, err)
+	}
+	return idx, nil
+}
+
+// add indexes a file, hash can be empty if it has not been computed yet
+func (i *duplicateIndex) add(path string, size int64, hash string) {
+	i.sizes[size] = true
+	if hash == "" {
+		i.pending[size] = append(i.pending[size], path)
+		return
+	}
+	if _, found := i.hashes[hash]; !found {
+		i.hashes[hash] = path
+	}
+}
+
+// find returns an indexed file having the same content as file, "" if there is none, and
+// the hash of file if it has been computed
+func (i *duplicateIndex) find(file string) (string, string, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", "", err
+	}
+	if !i.sizes[info.Size()] {
+		return "", "", nil
+	}
+	for _, p := range i.pending[info.Size()] {
+		h, err := hashFile(p)
+		if err != nil {
+			return "", "", fmt.Errorf("error while hashing %v: %w", p, err)
+		}
+		if _, found := i.hashes[h]; !found {
+			i.hashes[h] = p
+		}
+	}
+	delete(i.pending, info.Size())
+	hash, err := hashFile(file)
+	if err != nil {
+		return "", "", fmt.Errorf("error while hashing %v: %w", file, err)
+	}
+	return i.hashes[hash], hash, nil
+}
+
+// duplicateIndex returns the index of outputFolder, nil if duplicates are not detected
+func (dd *DateDispatcher) duplicateIndex(outputFolder string, stats *dispatchStats) *duplicateIndex {
+	if dd.duplicatePolicy == "" {
+		return nil
+	}
+	idx, err := buildDuplicateIndex(outputFolder)
+	if err != nil {
+		stats.addError(fmt.Errorf("duplicates won't be detected: %w", err))
+		return nil
+	}
+	return idx
+}
+
+// handleDuplicate skips or quarantines a file whose content already exists in the output
+// folder and returns true, it returns false if the file has to be hard linked. With
+// DuplicateHardlink, a file identical to its target is skipped since there is nothing to
+// link. Duplicates are only quarantined in move mode, the source must stay untouched in the
+// other modes so they are skipped.
+func (dd *DateDispatcher) handleDuplicate(l zerolog.Logger, outputFolder string, quarantineID string, ma moveAction, existing string, target string, mode string, stats *dispatchStats) bool {
+	policy := dd.duplicatePolicy
+	if existing == target && policy == DuplicateHardlink {
+		policy = DuplicateSkip
+	}
+	if policy == DuplicateQuarantine && mode != TransferMove {
+		policy = DuplicateSkip
+	}
+	switch policy {
+	case DuplicateSkip:
+		l.Info().Msgf("duplicate of %v, skipped", existing)
+		stats.update(func(r *Report) {
+			r.Duplicates = append(r.Duplicates, Duplicate{From: ma.from, Existing: existing, Action: duplicateSkipped})
+		})
+		return true
+	case DuplicateQuarantine:
+		to, err := availableQuarantinePath(filepath.Join(outputFolder, stateFolder, quarantineFolder, quarantineID, duplicatesFolder, ma.to))
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(to), 0777)
+		}
+		if err == nil {
+			err = move(ma.from, to)
+		}
+		if err != nil {
+			l.Error().Msgf("error when moving duplicate to quarantine: %v", err)
+			stats.addFileError(duplicateFileError)
+			return true
+		}
+		l.Info().Msgf("duplicate of %v, moved to %v", existing, to)
+		dd.recordInJournal(l, JournalQuarantine, ma.from, to, stats)
+		stats.update(func(r *Report) {
+			r.Duplicates = append(r.Duplicates, Duplicate{From: ma.from, Existing: existing, To: to, Action: duplicateQuarantined})
+		})
+		return true
+	}
+	return false
+}
+
+func availableQuarantinePath(path string) (string, error) {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return path, nil
+	}
+	return availableName(path)
+}
+
+// quarantineID is the folder of the quarantine where the files of the current dispatch are
+// moved
+func (dd *DateDispatcher) quarantineID() string {
+	if dd.journal != nil {
+		return dd.journal.id
+	}
+	return time.Now().Format(journalIDFormat)
+}
+
+// linkDuplicate hard links to to existing, a file having the same content as the file to
+// dispatch, and returns true. The source file is removed if files are moved. It returns
+// false if the hard link could not be created, so that the file is transferred instead.
+func (dd *DateDispatcher) linkDuplicate(l zerolog.Logger, ma moveAction, existing string, to string, mode string, stats *dispatchStats) bool {
+	if err := os.Link(existing, to); err != nil {
+		l.Warn().Msgf("error when linking duplicate of %v (%v), transferring it instead", existing, err)
+		return false
+	}
+	l.Info().Msgf("duplicate of %v, linked to %v", existing, to)
+	dd.recordInJournal(l, TransferHardlink, ma.from, to, stats)
+	if mode == TransferMove {
+		if err := os.Remove(ma.from); err != nil {
+			l.Error().Msgf("error when removing duplicate: %v", err)
+			stats.addFileError(duplicateFileError)
+		} else {
+			dd.recordInJournal(l, JournalDrop, ma.from, to, stats)
+		}
+	}
+	stats.update(func(r *Report) {
+		r.FilesTransferred++
+		r.Duplicates = append(r.Duplicates, Duplicate{From: ma.from, Existing: existing, To: to, Action: duplicateHardlinked})
+	})
+	return true
+}
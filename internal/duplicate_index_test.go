@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuplicateIndex(t *testing.T) {
+	outDir := t.TempDir()
+	jpg := filepath.Join(outDir, "2019_04", "a.jpg")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(jpg), 0777))
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", jpg))
+	assert.Nil(t, os.MkdirAll(filepath.Join(outDir, stateFolder), 0777))
+	assert.Nil(t, copy("../testdata/input/subFolder/noDate.txt", filepath.Join(outDir, stateFolder, "state.txt")))
+
+	srcDir := t.TempDir()
+	sameContent := filepath.Join(srcDir, "b.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", sameContent))
+	sameSize := filepath.Join(srcDir, "c.jpg")
+	content, err := os.ReadFile("../testdata/input/20190404_131804.jpg")
+	assert.Nil(t, err)
+	content[10] ^= 0xff
+	assert.Nil(t, os.WriteFile(sameSize, content, 0666))
+	stateContent := filepath.Join(srcDir, "d.txt")
+	assert.Nil(t, copy("../testdata/input/subFolder/noDate.txt", stateContent))
+
+	idx, err := buildDuplicateIndex(outDir)
+	assert.Nil(t, err)
+
+	var tcs = []struct {
+		tcID        string
+		file        string
+		expExisting string
+		expHashed   bool
+	}{
+		{"sameContent", sameContent, jpg, true},
+		{"sameSize", sameSize, "", true},
+		{"stateFolderIgnored", stateContent, "", false},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			existing, hash, err := idx.find(tc.file)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expExisting, existing)
+			assert.Equal(t, tc.expHashed, hash != "")
+		})
+	}
+
+	_, _, err = idx.find(filepath.Join(srcDir, "missing.jpg"))
+	assert.NotNil(t, err)
+}
+
+func TestBuildDuplicateIndexMissingFolder(t *testing.T) {
+	idx, err := buildDuplicateIndex(filepath.Join(t.TempDir(), "missing"))
+	assert.Nil(t, err)
+	existing, _, err := idx.find("../testdata/input/20190404_131804.jpg")
+	assert.Nil(t, err)
+	assert.Equal(t, "", existing)
+}
+
+func TestMoveFilesDuplicates(t *testing.T) {
+	var tcs = []struct {
+		tcID            string
+		policy          string
+		expSourceExists bool
+		expTargetExists bool
+		expAction       string
+	}{
+		{"skip", DuplicateSkip, true, false, duplicateSkipped},
+		{"quarantine", DuplicateQuarantine, false, false, duplicateQuarantined},
+		{"hardlink", DuplicateHardlink, false, true, duplicateHardlinked},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			outDir := filepath.Join(tmpDir, "out")
+			existing := filepath.Join(outDir, "2018_01", "old.jpg")
+			assert.Nil(t, os.MkdirAll(filepath.Dir(existing), 0777))
+			assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", existing))
+			inFile := filepath.Join(tmpDir, "new.jpg")
+			assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", inFile))
+			target := filepath.Join(outDir, "2019_04", "new.jpg")
+
+			j, err := OpenJournal(outDir)
+			assert.Nil(t, err)
+			c, err := NewDateDispatcher(OptDuplicatePolicy(tc.policy), OptJournal(j))
+			assert.Nil(t, err)
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			actionChan := make(chan moveAction, 1)
+			actionChan <- moveAction{from: inFile, to: filepath.Join("2019_04", "new.jpg")}
+			close(actionChan)
+			stats := newDispatchStats()
+			c.moveFiles(ctx, cancel, outDir, actionChan, nil, stats)
+			assert.Nil(t, j.Close())
+
+			checkExist(t, inFile, tc.expSourceExists)
+			checkExist(t, target, tc.expTargetExists)
+			r := stats.buildReport()
+			assert.Len(t, r.Duplicates, 1)
+			assert.Equal(t, tc.expAction, r.Duplicates[0].Action)
+			assert.Equal(t, existing, r.Duplicates[0].Existing)
+			switch tc.policy {
+			case DuplicateQuarantine:
+				checkExist(t, filepath.Join(outDir, stateFolder, quarantineFolder, j.id, duplicatesFolder, "2019_04", "new.jpg"), true)
+			case DuplicateHardlink:
+				ie, err := os.Stat(existing)
+				assert.Nil(t, err)
+				it, err := os.Stat(target)
+				assert.Nil(t, err)
+				assert.True(t, os.SameFile(ie, it))
+			}
+
+			// the journal restores the source, nothing has been recorded for a skipped file
+			_, err = Undo(outDir)
+			assert.Equal(t, tc.policy == DuplicateSkip, err != nil)
+			checkExist(t, inFile, true)
+			checkExist(t, target, false)
+			checkExist(t, existing, true)
+		})
+	}
+}
+
+func TestMoveFilesDuplicatesQuarantineCopyMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	outDir := filepath.Join(tmpDir, "out")
+	existing := filepath.Join(outDir, "2018_01", "old.jpg")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(existing), 0777))
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", existing))
+	inFile := filepath.Join(tmpDir, "new.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", inFile))
+
+	c, err := NewDateDispatcher(OptDuplicatePolicy(DuplicateQuarantine), OptTransferMode(TransferCopy))
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	actionChan := make(chan moveAction, 1)
+	actionChan <- moveAction{from: inFile, to: filepath.Join("2019_04", "new.jpg")}
+	close(actionChan)
+	stats := newDispatchStats()
+	c.moveFiles(ctx, cancel, outDir, actionChan, nil, stats)
+
+	checkExist(t, inFile, true)
+	checkExist(t, filepath.Join(outDir, "2019_04", "new.jpg"), false)
+	checkExist(t, filepath.Join(outDir, stateFolder, quarantineFolder), false)
+	r := stats.buildReport()
+	assert.Equal(t, []Duplicate{{From: inFile, Existing: existing, Action: duplicateSkipped}}, r.Duplicates)
+	assert.Nil(t, stats.err())
+}
+
+func TestMoveFilesDuplicatesWithinDispatch(t *testing.T) {
+	tmpDir := t.TempDir()
+	outDir := filepath.Join(tmpDir, "out")
+	inFile1 := filepath.Join(tmpDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", inFile1))
+	inFile2 := filepath.Join(tmpDir, "b.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", inFile2))
+
+	c, err := NewDateDispatcher(OptDuplicatePolicy(DuplicateSkip))
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	actionChan := make(chan moveAction, 2)
+	actionChan <- moveAction{from: inFile1, to: filepath.Join("2019_04", "a.jpg")}
+	actionChan <- moveAction{from: inFile2, to: filepath.Join("2019_04", "b.jpg")}
+	close(actionChan)
+	stats := newDispatchStats()
+	c.moveFiles(ctx, cancel, outDir, actionChan, nil, stats)
+
+	checkExist(t, filepath.Join(outDir, "2019_04", "a.jpg"), true)
+	checkExist(t, filepath.Join(outDir, "2019_04", "b.jpg"), false)
+	checkExist(t, inFile2, true)
+	r := stats.buildReport()
+	assert.Equal(t, 1, r.FilesTransferred)
+	assert.Equal(t, []Duplicate{{From: inFile2, Existing: filepath.Join(outDir, "2019_04", "a.jpg"), Action: duplicateSkipped}}, r.Duplicates)
+}
+
+func TestOptDuplicatePolicyUnsupported(t *testing.T) {
+	_, err := NewDateDispatcher(OptDuplicatePolicy("delete"))
+	assert.NotNil(t, err)
+}
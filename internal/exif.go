@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	exifIFDPointer = 0x8769
+
+	tiffASCII     = 2
+	tiffLong      = 4
+	tiffUndefined = 7
+	tiffIFD       = 13
+
+	maxIFDEntries  = 1024
+	maxExifString  = 64 * 1024
+	exifHeaderSize = 6
+)
+
+// exifTags are the EXIF tags read by the native extractor, named after exiftool
+var exifTags = map[uint16]string{
+	0x010f: "Make",
+	0x0110: "Model",
+	0x0131: "Software",
+	0x0132: "ModifyDate",
+	0x9003: "DateTimeOriginal",
+	0x9004: "CreateDate",
+	0x9010: "OffsetTime",
+	0x9011: "OffsetTimeOriginal",
+	0x9012: "OffsetTimeDigitized",
+	0x9290: "SubSecTime",
+	0x9291: "SubSecTimeOriginal",
+	0x9292: "SubSecTimeDigitized",
+	0xa433: "LensMake",
+	0xa434: "LensModel",
+}
+
+var errInvalidTIFF = errors.New("invalid TIFF header")
+
+// readJPEG reads the EXIF tags of the APP1 segment of a JPEG file
+func readJPEG(r io.ReaderAt, size int64, fields map[string]interface{}) error {
+	b := make([]byte, 4+exifHeaderSize)
+	for offset := int64(2); offset+4 <= size; {
+		if _, err := r.ReadAt(b[:4], offset); err != nil {
+			return fmt.Errorf("error while reading JPEG segment: %w", err)
+		}
+		if b[0] != 0xff {
+			return fmt.Errorf("invalid JPEG marker at offset %v", offset)
+		}
+		switch marker := b[1]; {
+		case marker == 0xff:
+			// fill byte
+			offset++
+			continue
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd8):
+			// standalone markers
+			offset += 2
+			continue
+		case marker == 0xd9 || marker == 0xda:
+			// metadata segments precede the image data
+			return nil
+		}
+		length := int64(binary.BigEndian.Uint16(b[2:]))
+		if b[1] == 0xe1 && length > 2+exifHeaderSize {
+			if _, err := r.ReadAt(b[4:], offset+4); err != nil {
+				return fmt.Errorf("error while reading JPEG segment: %w", err)
+			}
+			if string(b[4:]) == "Exif\x00\x00" {
+				tiff := io.NewSectionReader(r, offset+4+exifHeaderSize, length-2-exifHeaderSize)
+				return readTIFF(tiff, 0, fields)
+			}
+		}
+		offset += 2 + length
+	}
+	return nil
+}
+
+type tiffReader struct {
+	r     io.ReaderAt
+	base  int64
+	order binary.ByteOrder
+}
+
+// readTIFF reads the EXIF tags of the TIFF structure starting at offset base of r
+func readTIFF(r io.ReaderAt, base int64, fields map[string]interface{}) error {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, base); err != nil {
+		return fmt.Errorf("error while reading TIFF header: %w", err)
+	}
+	t := tiffReader{r: r, base: base}
+	switch string(header[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return errInvalidTIFF
+	}
+	if t.order.Uint16(header[2:]) != 42 {
+		return errInvalidTIFF
+	}
+	ifd0 := t.order.Uint32(header[4:])
+	exifIFD, err := t.readIFD(ifd0, fields)
+	if err != nil {
+		return err
+	}
+	if exifIFD != 0 && exifIFD != ifd0 {
+		if _, err := t.readIFD(exifIFD, fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readIFD reads the tags of the IFD at offset and returns the offset of the Exif IFD, 0
+// if it is not referenced
+func (t tiffReader) readIFD(offset uint32, fields map[string]interface{}) (uint32, error) {
+	b := make([]byte, 2)
+	if _, err := t.r.ReadAt(b, t.base+int64(offset)); err != nil {
+		return 0, fmt.Errorf("error while reading IFD: %w", err)
+	}
+	count := int(t.order.Uint16(b))
+	if count > maxIFDEntries {
+		return 0, fmt.Errorf("too many IFD entries (%v)", count)
+	}
+	entries := make([]byte, 12*count)
+	if _, err := t.r.ReadAt(entries, t.base+int64(offset)+2); err != nil {
+		return 0, fmt.Errorf("error while reading IFD: %w", err)
+	}
+
+	var exifIFD uint32
+	for i := 0; i < count; i++ {
+		e := entries[12*i : 12*(i+1)]
+		tag, typ, n := t.order.Uint16(e), t.order.Uint16(e[2:]), t.order.Uint32(e[4:])
+		if tag == exifIFDPointer && (typ == tiffLong || typ == tiffIFD) {
+			exifIFD = t.order.Uint32(e[8:])
+			continue
+		}
+		name, found := exifTags[tag]
+		if !found || (typ != tiffASCII && typ != tiffUndefined) || n > maxExifString {
+			continue
+		}
+		v := e[8:]
+		if n > 4 {
+			v = make([]byte, n)
+			if _, err := t.r.ReadAt(v, t.base+int64(t.order.Uint32(e[8:]))); err != nil {
+				// a broken value doesn't prevent reading the other tags
+				continue
+			}
+		}
+		if s := exifString(v[:n]); s != "" {
+			fields[name] = s
+		}
+	}
+	return exifIFD, nil
+}
+
+// exifString returns the value of an ASCII tag, without its NUL terminator and padding
+func exifString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,253 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/barasher/go-exiftool"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	exiftoolBinary    = "exiftool"
+	exiftoolReady     = "{ready}"
+	sourceFileField   = "SourceFile"
+	defaultBatchSize  = 16
+	maxExiftoolOutput = 64 * 1024 * 1024
+)
+
+// exiftoolExtractor drives an exiftool process in stay_open mode. Unlike go-exiftool, that
+// sends a request per file, it extracts the metadata of several files per request, and
+// only the requested tags.
+type exiftoolExtractor struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	scanner *bufio.Scanner
+	stderr  chan string
+	args    []string
+	// tags holds the requested tag names containing spaces, by exiftool tag name
+	tags map[string]string
+}
+
+// newExiftoolExtractor starts exiftool, every tag is extracted if tags is empty
+func newExiftoolExtractor(path string, tags []string) (*exiftoolExtractor, error) {
+	if path == "" {
+		path = exiftoolBinary
+	}
+	cmd := exec.Command(path, "-stay_open", "True", "-@", "-")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error when piping exiftool stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error when piping exiftool stdout: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error when piping exiftool stderr: %w", err)
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, fmt.Errorf("error when executing exiftool: %w", err)
+	}
+	e := newExiftoolSession(tags, stdin, stdout, stderr)
+	e.cmd = cmd
+	return e, nil
+}
+
+// newExiftoolSession drives the exiftool process whose standard streams are stdin, stdout
+// and stderr. Each request asks exiftool to print exiftoolReady to stderr once it is
+// processed (-echo4), so that the messages of a request can be told apart from the next
+// ones.
+func newExiftoolSession(tags []string, stdin io.WriteCloser, stdout io.Reader, stderr io.Reader) *exiftoolExtractor {
+	e := exiftoolExtractor{
+		stdin:  stdin,
+		stderr: make(chan string, 1),
+		args:   []string{"-j", "-charset", "filename=utf8", "-echo4", exiftoolReady},
+		tags:   make(map[string]string),
+	}
+	for _, t := range tags {
+		// exiftool tag names don't contain spaces ("Media Create Date" is MediaCreateDate)
+		name := strings.ReplaceAll(t, " ", "")
+		if name != t {
+			e.tags[name] = t
+		}
+		e.args = append(e.args, "-"+name)
+	}
+	e.scanner = bufio.NewScanner(stdout)
+	e.scanner.Buffer(nil, maxExiftoolOutput)
+	e.scanner.Split(splitExiftoolResponse)
+	go e.readStderr(stderr)
+	return &e
+}
+
+// readStderr sends the messages printed by exiftool to stderr, request by request. What
+// exiftool printed before exiting is sent last.
+func (e *exiftoolExtractor) readStderr(stderr io.Reader) {
+	defer close(e.stderr)
+	s := bufio.NewScanner(stderr)
+	s.Buffer(nil, maxExiftoolOutput)
+	s.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := splitExiftoolResponse(data, atEOF)
+		if err == io.ErrUnexpectedEOF {
+			return len(data), data, bufio.ErrFinalToken
+		}
+		return advance, token, err
+	})
+	for s.Scan() {
+		e.stderr <- strings.TrimSpace(s.Text())
+	}
+}
+
+// splitExiftoolResponse splits exiftool output on the token printed after each request
+func splitExiftoolResponse(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.Index(data, []byte(exiftoolReady)); i >= 0 {
+		return i + len(exiftoolReady), data[:i], nil
+	}
+	if atEOF && len(bytes.TrimSpace(data)) > 0 {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	return 0, nil, nil
+}
+
+// ExtractMetadata extracts the metadata of files with a single exiftool request, errors are
+// reported per file. Files are sent with their absolute path, so that a name starting with
+// a dash is not taken for an option. Names containing line breaks can't be sent through the
+// argument file and are rejected.
+func (e *exiftoolExtractor) ExtractMetadata(files ...string) []exiftool.FileMetadata {
+	fms := make([]exiftool.FileMetadata, len(files))
+	indexes := make(map[string]int, len(files))
+	var req strings.Builder
+	for _, a := range e.args {
+		fmt.Fprintln(&req, a)
+	}
+	for i, f := range files {
+		fms[i].File = f
+		if strings.ContainsAny(f, "\r\n") {
+			fms[i].Err = fmt.Errorf("file names containing line breaks are not supported by exiftool")
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			if os.IsNotExist(err) {
+				err = exiftool.ErrNotExist
+			}
+			fms[i].Err = err
+			continue
+		}
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			fms[i].Err = err
+			continue
+		}
+		// exiftool reports Windows paths with slashes
+		indexes[filepath.ToSlash(abs)] = i
+		fmt.Fprintln(&req, abs)
+	}
+	if len(indexes) == 0 {
+		return fms
+	}
+	fmt.Fprintln(&req, "-execute")
+
+	fail := func(err error) []exiftool.FileMetadata {
+		for _, i := range indexes {
+			fms[i].Err = err
+		}
+		return fms
+	}
+	if _, err := io.WriteString(e.stdin, req.String()); err != nil {
+		return fail(fmt.Errorf("error while sending request to exiftool: %w", err))
+	}
+	if !e.scanner.Scan() {
+		err := e.scanner.Err()
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		if msg := <-e.stderr; msg != "" {
+			err = fmt.Errorf("%w (%v)", err, msg)
+		}
+		return fail(fmt.Errorf("error while reading exiftool output: %w", err))
+	}
+	messages := exiftoolMessages(<-e.stderr, indexes)
+	out := bytes.TrimSpace(e.scanner.Bytes())
+	var resp []map[string]interface{}
+	if len(out) > 0 {
+		if err := json.Unmarshal(out, &resp); err != nil {
+			return fail(fmt.Errorf("error during unmarshaling (%v): %w", string(out), err))
+		}
+	}
+	for _, m := range resp {
+		src, _ := m[sourceFileField].(string)
+		if i, found := indexes[filepath.ToSlash(src)]; found {
+			for name, t := range e.tags {
+				if v, found := m[name]; found {
+					m[t] = v
+				}
+			}
+			fms[i].Fields = m
+		}
+	}
+	for _, i := range indexes {
+		msg := strings.Join(messages[i], "; ")
+		if fms[i].Fields == nil {
+			if msg == "" {
+				msg = "no metadata returned by exiftool"
+			}
+			fms[i].Err = errors.New(msg)
+		} else if msg != "" {
+			log.Warn().Str(fileLogField, files[i]).Msgf("exiftool: %v", msg)
+		}
+	}
+	for _, msg := range messages[-1] {
+		log.Warn().Msgf("exiftool: %v", msg)
+	}
+	return fms
+}
+
+// exiftoolMessages associates each line printed by exiftool to stderr to the index of the
+// file it mentions (e.g. "Error: File format error - /path/to/file.jpg"), -1 if it mentions
+// none
+func exiftoolMessages(stderr string, indexes map[string]int) map[int][]string {
+	messages := make(map[int][]string)
+	for _, line := range strings.Split(stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		i := -1
+		for f, idx := range indexes {
+			if strings.HasSuffix(filepath.ToSlash(line), " - "+f) {
+				i = idx
+				break
+			}
+		}
+		messages[i] = append(messages[i], line)
+	}
+	return messages
+}
+
+func (e *exiftoolExtractor) Close() error {
+	if _, err := io.WriteString(e.stdin, "-stay_open\nFalse\n-execute\n"); err != nil {
+		return fmt.Errorf("error while stopping exiftool: %w", err)
+	}
+	if err := e.stdin.Close(); err != nil {
+		return fmt.Errorf("error while closing exiftool stdin: %w", err)
+	}
+	// stderr is read until exiftool exits, before waiting for it
+	for range e.stderr {
+	}
+	if e.cmd == nil {
+		return nil
+	}
+	if err := e.cmd.Wait(); err != nil {
+		return fmt.Errorf("error while waiting for exiftool to exit: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/barasher/go-exiftool"
+	"github.com/stretchr/testify/assert"
+)
+
+// requireExiftool skips the tests of the exiftool extractor if exiftool is not installed
+func requireExiftool(tb testing.TB) {
+	if _, err := exec.LookPath(exiftoolBinary); err != nil {
+		tb.Skip("exiftool not installed")
+	}
+}
+
+func TestExiftoolExtractor(t *testing.T) {
+	requireExiftool(t)
+	e, err := newExiftoolExtractor("", []string{"CreateDate", "Media Create Date"})
+	assert.Nil(t, err)
+	defer func() { assert.Nil(t, e.Close()) }()
+	assert.Equal(t, []string{"-j", "-charset", "filename=utf8", "-echo4", exiftoolReady, "-CreateDate", "-MediaCreateDate"}, e.args)
+
+	files := []string{
+		"../testdata/input/20190404_131804.jpg",
+		"../testdata/input/nonExisting.jpg",
+		"../testdata/input/subFolder/noDate.txt",
+	}
+	fms := e.ExtractMetadata(files...)
+	assert.Len(t, fms, 3)
+	for i, fm := range fms {
+		assert.Equal(t, files[i], fm.File)
+	}
+	assert.Nil(t, fms[0].Err)
+	assert.Equal(t, "2019:04:04 13:18:03", fms[0].Fields["CreateDate"])
+	assert.Equal(t, exiftool.ErrNotExist, fms[1].Err)
+	assert.Nil(t, fms[2].Err)
+	_, found := fms[2].Fields["CreateDate"]
+	assert.False(t, found)
+
+	// the process is still usable after a request
+	fms = e.ExtractMetadata("../testdata/input/subFolder/20190404_131805.jpg")
+	assert.Nil(t, fms[0].Err)
+	assert.Equal(t, "2019:04:04 13:18:03", fms[0].Fields["CreateDate"])
+
+	fms = e.ExtractMetadata("../testdata/input/nonExisting.jpg")
+	assert.Equal(t, exiftool.ErrNotExist, fms[0].Err)
+}
+
+// cannedResponse is what a fake exiftool prints for a request, it exits after printing
+// stderr if crash
+type cannedResponse struct {
+	stdout string
+	stderr string
+	crash  bool
+}
+
+// startFakeExiftool drives a fake exiftool process answering the requests with responses,
+// the arguments of each request are sent to the returned channel
+func startFakeExiftool(tags []string, responses ...cannedResponse) (*exiftoolExtractor, chan []string) {
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	requests := make(chan []string, len(responses)+1)
+	go func() {
+		defer stdinR.Close()
+		defer stdoutW.Close()
+		defer stderrW.Close()
+		s := bufio.NewScanner(stdinR)
+		args := []string{}
+		for s.Scan() {
+			if s.Text() != "-execute" {
+				args = append(args, s.Text())
+				continue
+			}
+			requests <- args
+			args = []string{}
+			if len(responses) == 0 {
+				return
+			}
+			r := responses[0]
+			responses = responses[1:]
+			if r.crash {
+				io.WriteString(stderrW, r.stderr)
+				return
+			}
+			io.WriteString(stdoutW, r.stdout+exiftoolReady+"\n")
+			io.WriteString(stderrW, r.stderr+exiftoolReady+"\n")
+		}
+	}()
+	return newExiftoolSession(tags, stdinW, stdoutR, stderrR), requests
+}
+
+func TestExiftoolExtractorCannedOutput(t *testing.T) {
+	tmpDir := t.TempDir()
+	a := filepath.Join(tmpDir, "a.mov")
+	assert.Nil(t, os.WriteFile(a, []byte("a"), 0666))
+	b := filepath.Join(tmpDir, "b.mov")
+	assert.Nil(t, os.WriteFile(b, []byte("b"), 0666))
+	dash := filepath.Join(tmpDir, "-dash.mov")
+	assert.Nil(t, os.WriteFile(dash, []byte("dash"), 0666))
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(tmpDir))
+	defer os.Chdir(wd)
+
+	stdout, err := json.Marshal([]map[string]interface{}{
+		{sourceFileField: filepath.ToSlash(a), "MediaCreateDate": "2019:04:04 13:18:04"},
+		{sourceFileField: filepath.ToSlash(dash), "MediaCreateDate": "2019:04:04 13:18:05"},
+	})
+	assert.Nil(t, err)
+	e, requests := startFakeExiftool([]string{"Media Create Date"},
+		cannedResponse{
+			stdout: string(stdout),
+			stderr: "Warning: Truncated mdat atom - " + filepath.ToSlash(a) + "\nError: Unknown file type - " + filepath.ToSlash(b) + "\n",
+		},
+		cannedResponse{stderr: "Out of memory!\n", crash: true},
+	)
+
+	fms := e.ExtractMetadata(a, b, "c\n.mov", "-dash.mov")
+	assert.Equal(t, []string{"-j", "-charset", "filename=utf8", "-echo4", exiftoolReady, "-MediaCreateDate", a, b, dash}, <-requests)
+	assert.Len(t, fms, 4)
+	assert.Nil(t, fms[0].Err)
+	assert.Equal(t, "2019:04:04 13:18:04", fms[0].Fields["Media Create Date"])
+	assert.NotNil(t, fms[1].Err)
+	assert.Contains(t, fms[1].Err.Error(), "Unknown file type")
+	assert.NotNil(t, fms[2].Err)
+	assert.Contains(t, fms[2].Err.Error(), "line breaks")
+	assert.Equal(t, "-dash.mov", fms[3].File)
+	assert.Nil(t, fms[3].Err)
+	assert.Equal(t, "2019:04:04 13:18:05", fms[3].Fields["Media Create Date"])
+
+	// what exiftool printed before exiting is reported
+	fms = e.ExtractMetadata(a)
+	<-requests
+	assert.NotNil(t, fms[0].Err)
+	assert.Contains(t, fms[0].Err.Error(), "Out of memory!")
+	assert.NotNil(t, e.Close())
+}
+
+func TestExiftoolMessages(t *testing.T) {
+	indexes := map[string]int{"/in/a.jpg": 0, "/in/b.jpg": 1}
+	stderr := "Warning: [minor] Bad MakerNotes - /in/a.jpg\n\nError: File format error - /in/b.jpg\nError: something else\n"
+	assert.Equal(t, map[int][]string{
+		0:  {"Warning: [minor] Bad MakerNotes - /in/a.jpg"},
+		1:  {"Error: File format error - /in/b.jpg"},
+		-1: {"Error: something else"},
+	}, exiftoolMessages(stderr, indexes))
+}
+
+func TestNewExiftoolExtractorNonExistingBinary(t *testing.T) {
+	_, err := newExiftoolExtractor(filepath.Join(t.TempDir(), "exiftool"), nil)
+	assert.NotNil(t, err)
+}
+
+func TestSplitExiftoolResponse(t *testing.T) {
+	var tcs = []struct {
+		tcID       string
+		data       string
+		atEOF      bool
+		expAdvance int
+		expToken   string
+		expErr     error
+	}{
+		{"complete", "[{}]\n{ready}\n[", false, 12, "[{}]\n", nil},
+		{"incomplete", "[{}]\n{rea", false, 0, "", nil},
+		{"empty", "", true, 0, "", nil},
+		{"truncated", "[{}]\n", true, 0, "", io.ErrUnexpectedEOF},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			advance, token, err := splitExiftoolResponse([]byte(tc.data), tc.atEOF)
+			assert.Equal(t, tc.expAdvance, advance)
+			assert.Equal(t, tc.expToken, string(token))
+			assert.Equal(t, tc.expErr, err)
+		})
+	}
+}
+
+func TestNextBatch(t *testing.T) {
+	c, err := NewDateDispatcher(OptBatchSize(2))
+	assert.Nil(t, err)
+	filesChan := make(chan string, 3)
+	filesChan <- "b"
+	filesChan <- "c"
+
+	assert.Equal(t, []string{"a", "b"}, c.nextBatch(filesChan, "a"))
+	// no wait for files that are not available yet
+	assert.Equal(t, []string{"c"}, c.nextBatch(filesChan, <-filesChan))
+	close(filesChan)
+	assert.Equal(t, []string{"d"}, c.nextBatch(filesChan, "d"))
+}
+
+func TestOptBatchSize(t *testing.T) {
+	_, err := NewDateDispatcher(OptBatchSize(0))
+	assert.NotNil(t, err)
+}
+
+// BenchmarkExtractMetadata compares the extraction of the testdata set one file per request
+// with every tag (go-exiftool) and by batches restricted to the dispatcher tags
+func BenchmarkExtractMetadata(b *testing.B) {
+	requireExiftool(b)
+	files := []string{}
+	err := filepath.Walk("../testdata", func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	})
+	assert.Nil(b, err)
+	c, err := NewDateDispatcher(OptOrderedDateFields([]DateField{{Field: "CreateDate", Pattern: "2006:01:02 15:04:05"}}))
+	assert.Nil(b, err)
+	tags, _ := c.relevantTags()
+
+	b.Run("perFile", func(b *testing.B) {
+		e, err := exiftool.NewExiftool()
+		assert.Nil(b, err)
+		defer e.Close()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, f := range files {
+				e.ExtractMetadata(f)
+			}
+		}
+	})
+
+	for _, size := range []int{1, defaultBatchSize} {
+		b.Run(fmt.Sprintf("batch%v", size), func(b *testing.B) {
+			e, err := newExiftoolExtractor("", tags)
+			assert.Nil(b, err)
+			defer e.Close()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < len(files); j += size {
+					end := j + size
+					if end > len(files) {
+						end = len(files)
+					}
+					e.ExtractMetadata(files[j:end]...)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/barasher/go-exiftool"
+)
+
+// FakeExtractor is an in-memory MetadataExtractor, so that the dispatch can be tested
+// without exiftool. Metadata, errors and delays are defined per file path, files without
+// metadata get none (like exiftool for a file without tags). It is safe for concurrent use
+// and is shared by every worker (see OptExtractorFactory and Factory).
+type FakeExtractor struct {
+	mutex     sync.Mutex
+	fields    map[string]map[string]interface{}
+	errs      map[string]error
+	delays    map[string]time.Duration
+	extracted []string
+	tags      []string
+}
+
+func NewFakeExtractor() *FakeExtractor {
+	return &FakeExtractor{
+		fields: make(map[string]map[string]interface{}),
+		errs:   make(map[string]error),
+		delays: make(map[string]time.Duration),
+	}
+}
+
+// SetFields defines the metadata of file
+func (f *FakeExtractor) SetFields(file string, fields map[string]interface{}) *FakeExtractor {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.fields[filepath.Clean(file)] = fields
+	return f
+}
+
+// SetError makes the extraction of file fail with err
+func (f *FakeExtractor) SetError(file string, err error) *FakeExtractor {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.errs[filepath.Clean(file)] = err
+	return f
+}
+
+// SetDelay makes the extraction of file last d
+func (f *FakeExtractor) SetDelay(file string, d time.Duration) *FakeExtractor {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.delays[filepath.Clean(file)] = d
+	return f
+}
+
+// Extracted returns the files whose metadata have been extracted, in extraction order
+func (f *FakeExtractor) Extracted() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.extracted...)
+}
+
+// Tags returns the tags requested by the dispatcher, nil if every tag is needed
+func (f *FakeExtractor) Tags() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.tags
+}
+
+// Factory returns an ExtractorFactory providing f to every worker
+func (f *FakeExtractor) Factory() ExtractorFactory {
+	return func(tags []string) (MetadataExtractor, error) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		f.tags = tags
+		return f, nil
+	}
+}
+
+func (f *FakeExtractor) ExtractMetadata(files ...string) []exiftool.FileMetadata {
+	fms := make([]exiftool.FileMetadata, len(files))
+	for i, file := range files {
+		key := filepath.Clean(file)
+		f.mutex.Lock()
+		delay := f.delays[key]
+		f.mutex.Unlock()
+		time.Sleep(delay)
+
+		f.mutex.Lock()
+		f.extracted = append(f.extracted, file)
+		fms[i] = exiftool.FileMetadata{File: file, Err: f.errs[key]}
+		if fms[i].Err == nil {
+			fms[i].Fields = map[string]interface{}{sourceFileField: file}
+			for k, v := range f.fields[key] {
+				fms[i].Fields[k] = v
+			}
+		}
+		f.mutex.Unlock()
+	}
+	return fms
+}
+
+func (f *FakeExtractor) Close() error {
+	return nil
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeExtractor(t *testing.T) {
+	errBroken := errors.New("broken")
+	f := NewFakeExtractor().
+		SetFields("in/./a.jpg", map[string]interface{}{"CreateDate": "2019:04:04 13:18:04"}).
+		SetError("in/b.jpg", errBroken).
+		SetDelay("in/c.jpg", 20*time.Millisecond)
+
+	start := time.Now()
+	fms := f.ExtractMetadata("in/a.jpg", "in/b.jpg", "in/c.jpg")
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(20*time.Millisecond))
+	assert.Len(t, fms, 3)
+	assert.Nil(t, fms[0].Err)
+	assert.Equal(t, map[string]interface{}{"SourceFile": "in/a.jpg", "CreateDate": "2019:04:04 13:18:04"}, fms[0].Fields)
+	assert.Equal(t, errBroken, fms[1].Err)
+	assert.Nil(t, fms[2].Err)
+	assert.Equal(t, map[string]interface{}{"SourceFile": "in/c.jpg"}, fms[2].Fields)
+	assert.Equal(t, []string{"in/a.jpg", "in/b.jpg", "in/c.jpg"}, f.Extracted())
+	assert.Nil(t, f.Close())
+}
+
+func TestGetMoveActionsFakeExtractor(t *testing.T) {
+	fake := NewFakeExtractor().
+		SetFields("a.jpg", map[string]interface{}{"CreateDate": "2019:04:04 13:18:04"}).
+		SetError("b.jpg", errors.New("broken"))
+	c, err := NewDateDispatcher(
+		OptThreadCount(2),
+		OptOrderedDateFields([]DateField{{Field: "CreateDate", Pattern: "2006:01:02 15:04:05"}}),
+		OptExtractorFactory(fake.Factory()),
+	)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	fileChan := make(chan string, 3)
+	fileChan <- "a.jpg"
+	fileChan <- "b.jpg"
+	fileChan <- "c.jpg"
+	close(fileChan)
+	actionChan := make(chan moveAction, 3)
+	stats := newDispatchStats()
+	assert.Nil(t, c.getMoveActions(ctx, cancel, fileChan, actionChan, nil, nil, stats))
+
+	actions := []moveAction{}
+	for ma := range actionChan {
+		actions = append(actions, ma)
+	}
+	assert.Equal(t, []moveAction{{from: "a.jpg", to: filepath.Join("2019_04", "a.jpg"), source: "CreateDate", date: time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)}}, actions)
+	r := stats.buildReport()
+	assert.Equal(t, 1, r.MetadataErrors)
+	assert.Equal(t, 1, r.SkippedNoDate)
+	assert.ElementsMatch(t, []string{"a.jpg", "b.jpg", "c.jpg"}, fake.Extracted())
+	assert.Equal(t, []string{"CreateDate", "Make", "Model"}, fake.Tags())
+}
+
+func TestGetMoveActionsExtractionErrorFallbacks(t *testing.T) {
+	tmpDir := t.TempDir()
+	named := filepath.Join(tmpDir, "20190404_131804.jpg")
+	assert.Nil(t, os.WriteFile(named, []byte("a"), 0666))
+	dated := filepath.Join(tmpDir, "a.jpg")
+	assert.Nil(t, os.WriteFile(dated, []byte("a"), 0666))
+	mtime := time.Date(2018, time.May, 6, 7, 8, 9, 0, time.UTC)
+	assert.Nil(t, os.Chtimes(dated, mtime, mtime))
+	fake := NewFakeExtractor().
+		SetError(named, errors.New("broken")).
+		SetError(dated, errors.New("broken"))
+
+	var tcs = []struct {
+		tcID           string
+		opts           []func(*DateDispatcher) error
+		expSources     []string
+		expMetadataErr int
+	}{
+		{"noFallback", nil, []string{}, 2},
+		{"fileName", []func(*DateDispatcher) error{OptFileNamePatterns([]FileNamePattern{{Regex: `^(\d{8}_\d{6})`, Pattern: "20060102_150405"}})}, []string{fileNameDateSource}, 1},
+		{"fileTime", []func(*DateDispatcher) error{
+			OptFileNamePatterns([]FileNamePattern{{Regex: `^(\d{8}_\d{6})`, Pattern: "20060102_150405"}}),
+			OptFileTimeFallback(FileTimeModification),
+		}, []string{fileNameDateSource, FileTimeModification}, 0},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			opts := append([]func(*DateDispatcher) error{
+				OptOrderedDateFields([]DateField{{Field: "CreateDate", Pattern: "2006:01:02 15:04:05"}}),
+				OptExtractorFactory(fake.Factory()),
+				OptOutputZone("UTC"),
+			}, tc.opts...)
+			c, err := NewDateDispatcher(opts...)
+			assert.Nil(t, err)
+
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			fileChan := make(chan string, 2)
+			fileChan <- named
+			fileChan <- dated
+			close(fileChan)
+			actionChan := make(chan moveAction, 2)
+			stats := newDispatchStats()
+			assert.Nil(t, c.getMoveActions(ctx, cancel, fileChan, actionChan, nil, nil, stats))
+
+			sources := []string{}
+			for ma := range actionChan {
+				sources = append(sources, ma.source)
+			}
+			assert.ElementsMatch(t, tc.expSources, sources)
+			r := stats.buildReport()
+			assert.Equal(t, tc.expMetadataErr, r.MetadataErrors)
+			assert.Equal(t, 0, r.SkippedNoDate)
+		})
+	}
+}
+
+func TestOptExtractorFactoryNil(t *testing.T) {
+	_, err := NewDateDispatcher(OptExtractorFactory(nil))
+	assert.NotNil(t, err)
+}
+
+func TestDispatchContextCanceledDuringExtraction(t *testing.T) {
+	tmpDir := t.TempDir()
+	inDir := filepath.Join(tmpDir, "in")
+	assert.Nil(t, os.MkdirAll(inDir, 0777))
+	outDir := filepath.Join(tmpDir, "out")
+	fake := NewFakeExtractor()
+	for i := 0; i < 5; i++ {
+		f := filepath.Join(inDir, fmt.Sprintf("%v.jpg", i))
+		assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", f))
+		fake.SetFields(f, map[string]interface{}{"CreateDate": "2019:04:04 13:18:04"})
+		fake.SetDelay(f, 50*time.Millisecond)
+	}
+	c, err := NewDateDispatcher(
+		OptThreadCount(1),
+		OptBatchSize(1),
+		OptOrderedDateFields([]DateField{{Field: "CreateDate", Pattern: "2006:01:02 15:04:05"}}),
+		OptExtractorFactory(fake.Factory()),
+	)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+	report, err := c.DispatchContext(ctx, inDir, outDir)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "interrupted")
+	assert.Less(t, report.FilesTransferred, 5)
+	assert.Less(t, len(fake.Extracted()), 5)
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OptIncludeGlobs restricts the dispatch to the files matching one of the globs. Globs are
+// matched, case insensitively, against the file name and against the path relative to the
+// input folder (using "/" as separator).
+func OptIncludeGlobs(globs []string) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		if err := checkGlobs(globs); err != nil {
+			return err
+		}
+		c.includeGlobs = append(c.includeGlobs, globs...)
+		return nil
+	}
+}
+
+// OptExcludeGlobs ignores the files and folders matching one of the globs (see
+// OptIncludeGlobs). Exclusions take precedence over inclusions.
+func OptExcludeGlobs(globs []string) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		if err := checkGlobs(globs); err != nil {
+			return err
+		}
+		c.excludeGlobs = append(c.excludeGlobs, globs...)
+		return nil
+	}
+}
+
+// OptSkipHidden ignores the files and folders whose name starts with a dot (.DS_Store,
+// .nomedia, .thumbnails, ...)
+func OptSkipHidden() func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		c.skipHidden = true
+		return nil
+	}
+}
+
+// OptMaxDepth limits how deep the input folder is browsed : 1 only considers the files of
+// the input folder itself, 0 (default) means no limit
+func OptMaxDepth(depth int) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		if depth < 0 {
+			return fmt.Errorf("invalid max depth: %v", depth)
+		}
+		c.maxDepth = depth
+		return nil
+	}
+}
+
+func checkGlobs(globs []string) error {
+	for _, g := range globs {
+		if _, err := filepath.Match(g, ""); err != nil {
+			return fmt.Errorf("invalid glob %v: %w", g, err)
+		}
+	}
+	return nil
+}
+
+func matchesGlob(globs []string, rel string) bool {
+	rel = strings.ToLower(filepath.ToSlash(rel))
+	name := rel[strings.LastIndex(rel, "/")+1:]
+	for _, g := range globs {
+		g = strings.ToLower(g)
+		if m, _ := filepath.Match(g, name); m {
+			return true
+		}
+		if m, _ := filepath.Match(g, rel); m {
+			return true
+		}
+	}
+	return false
+}
+
+// fileFilter decides which files of the input folder are dispatched
+type fileFilter struct {
+	dd           *DateDispatcher
+	inputFolder  string
+	outputFolder string
+}
+
+// newFileFilter returns a filter for inputFolder. outputFolder is excluded if it sits inside
+// inputFolder, it is ignored if empty.
+func (dd *DateDispatcher) newFileFilter(inputFolder string, outputFolder string) fileFilter {
+	f := fileFilter{dd: dd, inputFolder: inputFolder}
+	if outputFolder != "" {
+		if abs, err := filepath.Abs(outputFolder); err == nil {
+			f.outputFolder = abs
+		}
+	}
+	return f
+}
+
+// skip returns true if path has to be ignored, along with filepath.SkipDir for the
+// folders that must not be browsed
+func (f fileFilter) skip(path string, info os.FileInfo) (bool, error) {
+	rel, err := filepath.Rel(f.inputFolder, path)
+	if err != nil || rel == "." {
+		return false, nil
+	}
+	skipped := filepath.SkipDir
+	if !info.IsDir() {
+		skipped = nil
+	}
+	if info.IsDir() && info.Name() == stateFolder {
+		return true, skipped
+	}
+	if info.IsDir() && f.outputFolder != "" {
+		if abs, err := filepath.Abs(path); err == nil && abs == f.outputFolder {
+			return true, skipped
+		}
+	}
+	if f.dd.skipHidden && strings.HasPrefix(info.Name(), ".") {
+		return true, skipped
+	}
+	if matchesGlob(f.dd.excludeGlobs, rel) {
+		return true, skipped
+	}
+	depth := strings.Count(filepath.ToSlash(rel), "/") + 1
+	if info.IsDir() {
+		if f.dd.maxDepth > 0 && depth >= f.dd.maxDepth {
+			return true, skipped
+		}
+		return false, nil
+	}
+	if len(f.dd.includeGlobs) > 0 && !matchesGlob(f.dd.includeGlobs, rel) {
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchesGlob(t *testing.T) {
+	var tcs = []struct {
+		tcID     string
+		globs    []string
+		rel      string
+		expMatch bool
+	}{
+		{"name", []string{"Thumbs.db"}, filepath.Join("a", "thumbs.DB"), true},
+		{"extension", []string{"*.xmp"}, filepath.Join("a", "IMG_0001.XMP"), true},
+		{"relativePath", []string{"a/*.jpg"}, filepath.Join("a", "IMG_0001.jpg"), true},
+		{"relativePathNoMatch", []string{"b/*.jpg"}, filepath.Join("a", "IMG_0001.jpg"), false},
+		{"noGlob", nil, "IMG_0001.jpg", false},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			assert.Equal(t, tc.expMatch, matchesGlob(tc.globs, tc.rel))
+		})
+	}
+}
+
+func TestListFilesFilters(t *testing.T) {
+	inDir := t.TempDir()
+	files := []string{
+		"a.jpg",
+		"a.xmp",
+		".DS_Store",
+		"Thumbs.db",
+		filepath.Join("sub", "b.JPG"),
+		filepath.Join("sub", "deep", "c.jpg"),
+		filepath.Join(".thumbnails", "d.jpg"),
+		filepath.Join("out", "2019_04", "e.jpg"),
+		filepath.Join("cache", "f.jpg"),
+	}
+	for _, f := range files {
+		assert.Nil(t, os.MkdirAll(filepath.Join(inDir, filepath.Dir(f)), 0777))
+		assert.Nil(t, os.WriteFile(filepath.Join(inDir, f), []byte{}, 0666))
+	}
+
+	var tcs = []struct {
+		tcID        string
+		opts        []func(*DateDispatcher) error
+		expFiles    []string
+		expExcluded int
+	}{
+		{"outputFolderOnly", nil, []string{".DS_Store", filepath.Join(".thumbnails", "d.jpg"), "Thumbs.db", "a.jpg", "a.xmp", filepath.Join("cache", "f.jpg"), filepath.Join("sub", "b.JPG"), filepath.Join("sub", "deep", "c.jpg")}, 0},
+		{"hidden", []func(*DateDispatcher) error{OptSkipHidden()}, []string{"Thumbs.db", "a.jpg", "a.xmp", filepath.Join("cache", "f.jpg"), filepath.Join("sub", "b.JPG"), filepath.Join("sub", "deep", "c.jpg")}, 1},
+		{"include", []func(*DateDispatcher) error{OptIncludeGlobs([]string{"*.jpg"})}, []string{filepath.Join(".thumbnails", "d.jpg"), "a.jpg", filepath.Join("cache", "f.jpg"), filepath.Join("sub", "b.JPG"), filepath.Join("sub", "deep", "c.jpg")}, 3},
+		{"exclude", []func(*DateDispatcher) error{OptSkipHidden(), OptExcludeGlobs([]string{"thumbs.db", "*.xmp", "cache"})}, []string{"a.jpg", filepath.Join("sub", "b.JPG"), filepath.Join("sub", "deep", "c.jpg")}, 3},
+		{"maxDepth", []func(*DateDispatcher) error{OptSkipHidden(), OptMaxDepth(2)}, []string{"Thumbs.db", "a.jpg", "a.xmp", filepath.Join("cache", "f.jpg"), filepath.Join("sub", "b.JPG")}, 1},
+		{"maxDepthOne", []func(*DateDispatcher) error{OptSkipHidden(), OptMaxDepth(1)}, []string{"Thumbs.db", "a.jpg", "a.xmp"}, 1},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			c, err := NewDateDispatcher(tc.opts...)
+			assert.Nil(t, err)
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			filesChan := make(chan string, len(files))
+			stats := newDispatchStats()
+			assert.Nil(t, c.listFiles(ctx, cancel, inDir, filepath.Join(inDir, "out"), filesChan, stats))
+
+			got := []string{}
+			for f := range filesChan {
+				rel, err := filepath.Rel(inDir, f)
+				assert.Nil(t, err)
+				got = append(got, rel)
+			}
+			sort.Strings(got)
+			assert.Equal(t, tc.expFiles, got)
+			r := stats.buildReport()
+			assert.Equal(t, len(tc.expFiles), r.FilesScanned)
+			assert.Equal(t, tc.expExcluded, r.FilesExcluded)
+		})
+	}
+}
+
+func TestFilterOptionsInvalid(t *testing.T) {
+	_, err := NewDateDispatcher(OptIncludeGlobs([]string{"[a-"}))
+	assert.NotNil(t, err)
+	_, err = NewDateDispatcher(OptExcludeGlobs([]string{"[a-"}))
+	assert.NotNil(t, err)
+	_, err = NewDateDispatcher(OptMaxDepth(-1))
+	assert.NotNil(t, err)
+}
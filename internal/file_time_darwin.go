@@ -0,0 +1,24 @@
+//go:build darwin
+// +build darwin
+
+package internal
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func changeTime(info os.FileInfo) (time.Time, bool) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Ctimespec.Sec, st.Ctimespec.Nsec), true
+	}
+	return time.Time{}, false
+}
+
+func birthTime(info os.FileInfo) (time.Time, bool) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(st.Birthtimespec.Sec, st.Birthtimespec.Nsec), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,21 @@
+//go:build linux
+// +build linux
+
+package internal
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func changeTime(info os.FileInfo) (time.Time, bool) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec)), true
+	}
+	return time.Time{}, false
+}
+
+func birthTime(info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
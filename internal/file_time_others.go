@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package internal
+
+import (
+	"os"
+	"time"
+)
+
+func changeTime(info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func birthTime(info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+package internal
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func changeTime(info os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func birthTime(info os.FileInfo) (time.Time, bool) {
+	if d, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, d.CreationTime.Nanoseconds()), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,370 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	maxBoxPayload = 16 * 1024 * 1024
+	exifDateTime  = "2006:01:02 15:04:05"
+	zeroDateTime  = "0000:00:00 00:00:00"
+)
+
+// quickTimeEpoch is the origin of the dates of MP4 and MOV files
+var quickTimeEpoch = time.Date(1904, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var errTruncatedBox = errors.New("truncated box")
+
+// isoBox is a box of an ISO base media file (MP4, MOV, HEIC, ...)
+type isoBox struct {
+	typ string
+	// offset is the offset of the payload in the file
+	offset int64
+	size   int64
+}
+
+// isISOBox returns true if typ is the type of a box that can start an ISO base media file
+func isISOBox(typ []byte) bool {
+	switch string(typ) {
+	case "ftyp", "moov", "mdat", "wide", "free", "skip", "pnot":
+		return true
+	}
+	return false
+}
+
+// readBoxes reads the headers of the boxes between offsets start and end of r
+func readBoxes(r io.ReaderAt, start int64, end int64) ([]isoBox, error) {
+	boxes := []isoBox{}
+	h := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := r.ReadAt(h[:8], offset); err != nil {
+			return nil, fmt.Errorf("error while reading box header: %w", err)
+		}
+		size, typ, header := int64(binary.BigEndian.Uint32(h)), string(h[4:8]), int64(8)
+		switch size {
+		case 0:
+			// the box extends to the end of its parent
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(h[8:], offset+8); err != nil {
+				return nil, fmt.Errorf("error while reading box header: %w", err)
+			}
+			size, header = int64(binary.BigEndian.Uint64(h[8:])), 16
+		}
+		if size < header {
+			return nil, fmt.Errorf("invalid %q box size (%v)", typ, size)
+		}
+		if offset+size > end {
+			// truncated file, the beginning of the box may still be readable
+			size = end - offset
+		}
+		boxes = append(boxes, isoBox{typ: typ, offset: offset + header, size: size - header})
+		offset += size
+	}
+	return boxes, nil
+}
+
+func findBox(boxes []isoBox, typ string) (isoBox, bool) {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return isoBox{}, false
+}
+
+func readBoxPayload(r io.ReaderAt, b isoBox) ([]byte, error) {
+	if b.size > maxBoxPayload {
+		return nil, fmt.Errorf("%q box too large (%v)", b.typ, b.size)
+	}
+	p := make([]byte, b.size)
+	if _, err := r.ReadAt(p, b.offset); err != nil {
+		return nil, fmt.Errorf("error while reading %q box: %w", b.typ, err)
+	}
+	return p, nil
+}
+
+// isoFileType returns the exiftool FileType and MIMEType of an ISO base media file
+func isoFileType(brand string) (string, string) {
+	switch brand {
+	case "", "qt  ":
+		return "MOV", "video/quicktime"
+	case "heic", "heix", "heim", "heis", "hevc", "hevx", "hevm", "hevs":
+		return "HEIC", "image/heic"
+	case "mif1", "msf1":
+		return "HEIF", "image/heif"
+	case "avif", "avis":
+		return "AVIF", "image/avif"
+	case "M4V ":
+		return "M4V", "video/x-m4v"
+	case "3gp4", "3gp5", "3gp6", "3g2a":
+		return "3GP", "video/3gpp"
+	}
+	return "MP4", "video/mp4"
+}
+
+// readISOBMFF reads the EXIF tags of HEIC/HEIF files and the creation dates of movies
+func readISOBMFF(r io.ReaderAt, size int64, fields map[string]interface{}) error {
+	boxes, err := readBoxes(r, 0, size)
+	if err != nil {
+		return err
+	}
+	brand := ""
+	if ftyp, found := findBox(boxes, "ftyp"); found && ftyp.size >= 4 {
+		b := make([]byte, 4)
+		if _, err := r.ReadAt(b, ftyp.offset); err != nil {
+			return fmt.Errorf("error while reading file type: %w", err)
+		}
+		brand = string(b)
+	}
+	fileType, mimeType := isoFileType(brand)
+	fields[fileTypeField] = fileType
+	fields[mimeTypeField] = mimeType
+
+	if meta, found := findBox(boxes, "meta"); found && fileType != "MOV" {
+		if err := readHEIFExif(r, meta, fields); err != nil {
+			return err
+		}
+	}
+	if moov, found := findBox(boxes, "moov"); found {
+		return readMovieHeaders(r, moov, fields)
+	}
+	return nil
+}
+
+// readHEIFExif reads the EXIF tags of the Exif item of a HEIF meta box
+func readHEIFExif(r io.ReaderAt, meta isoBox, fields map[string]interface{}) error {
+	// meta is a full box, version and flags precede its children
+	children, err := readBoxes(r, meta.offset+4, meta.offset+meta.size)
+	if err != nil {
+		return err
+	}
+	iinf, found := findBox(children, "iinf")
+	if !found {
+		return nil
+	}
+	p, err := readBoxPayload(r, iinf)
+	if err != nil {
+		return err
+	}
+	id, found, err := exifItemID(p)
+	if err != nil || !found {
+		return err
+	}
+	iloc, found := findBox(children, "iloc")
+	if !found {
+		return fmt.Errorf("no location for Exif item %v", id)
+	}
+	if p, err = readBoxPayload(r, iloc); err != nil {
+		return err
+	}
+	method, extents, err := itemExtents(p, id)
+	if err != nil {
+		return err
+	}
+
+	var base int64
+	switch method {
+	case 0:
+		// offsets in the file
+	case 1:
+		idat, found := findBox(children, "idat")
+		if !found {
+			return fmt.Errorf("no idat box for Exif item %v", id)
+		}
+		base = idat.offset
+	default:
+		return fmt.Errorf("unsupported construction method (%v) for Exif item %v", method, id)
+	}
+	var data []byte
+	for _, e := range extents {
+		if e[1] == 0 || uint64(len(data))+e[1] > maxBoxPayload {
+			return fmt.Errorf("invalid Exif item extent length (%v)", e[1])
+		}
+		b := make([]byte, e[1])
+		if _, err := r.ReadAt(b, base+int64(e[0])); err != nil {
+			return fmt.Errorf("error while reading Exif item: %w", err)
+		}
+		data = append(data, b...)
+	}
+	// the item starts with the offset of the TIFF header
+	if len(data) < 4 {
+		return fmt.Errorf("truncated Exif item")
+	}
+	return readTIFF(bytes.NewReader(data), 4+int64(binary.BigEndian.Uint32(data)), fields)
+}
+
+// exifItemID returns the identifier of the Exif item declared in an iinf box payload
+func exifItemID(p []byte) (uint32, bool, error) {
+	if len(p) < 4 {
+		return 0, false, errTruncatedBox
+	}
+	start := int64(6)
+	if p[0] != 0 {
+		start = 8
+	}
+	entries, err := readBoxes(bytes.NewReader(p), start, int64(len(p)))
+	if err != nil {
+		return 0, false, err
+	}
+	for _, b := range entries {
+		if b.typ != "infe" {
+			continue
+		}
+		e := p[b.offset : b.offset+b.size]
+		var id uint32
+		var typ string
+		switch {
+		case len(e) >= 12 && e[0] == 2:
+			id, typ = uint32(binary.BigEndian.Uint16(e[4:])), string(e[8:12])
+		case len(e) >= 14 && e[0] == 3:
+			id, typ = binary.BigEndian.Uint32(e[4:]), string(e[10:14])
+		default:
+			continue
+		}
+		if typ == "Exif" {
+			return id, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// boxCursor reads the big endian integers of a box payload
+type boxCursor struct {
+	p   []byte
+	pos int
+	err error
+}
+
+func (c *boxCursor) uint(size int) uint64 {
+	if c.err != nil || size == 0 {
+		return 0
+	}
+	if size > 8 || c.pos+size > len(c.p) {
+		c.err = errTruncatedBox
+		return 0
+	}
+	var v uint64
+	for _, b := range c.p[c.pos : c.pos+size] {
+		v = v<<8 | uint64(b)
+	}
+	c.pos += size
+	return v
+}
+
+// itemExtents returns the construction method and the extents (offset, length) of an
+// item, read from an iloc box payload
+func itemExtents(p []byte, id uint32) (uint64, [][2]uint64, error) {
+	c := boxCursor{p: p}
+	version := c.uint(1)
+	c.uint(3) // flags
+	sizes := c.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0xf)
+	sizes = c.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0xf)
+	}
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+	count := c.uint(idSize)
+	for i := uint64(0); i < count && c.err == nil; i++ {
+		itemID := c.uint(idSize)
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = c.uint(2) & 0xf
+		}
+		c.uint(2) // data reference index
+		base := c.uint(baseOffsetSize)
+		extentCount := c.uint(2)
+		extents := [][2]uint64{}
+		for j := uint64(0); j < extentCount && c.err == nil; j++ {
+			c.uint(indexSize)
+			offset := c.uint(offsetSize)
+			extents = append(extents, [2]uint64{base + offset, c.uint(lengthSize)})
+		}
+		if c.err == nil && itemID == uint64(id) {
+			return method, extents, nil
+		}
+	}
+	if c.err != nil {
+		return 0, nil, fmt.Errorf("error while reading item locations: %w", c.err)
+	}
+	return 0, nil, fmt.Errorf("no location for Exif item %v", id)
+}
+
+// readMovieHeaders reads the dates of the movie header (mvhd) and of the media header (mdhd)
+// of the first track of a moov box
+func readMovieHeaders(r io.ReaderAt, moov isoBox, fields map[string]interface{}) error {
+	children, err := readBoxes(r, moov.offset, moov.offset+moov.size)
+	if err != nil {
+		return err
+	}
+	if mvhd, found := findBox(children, "mvhd"); found {
+		if err := readMediaDates(r, mvhd, "CreateDate", "ModifyDate", fields); err != nil {
+			return err
+		}
+	}
+	for _, trak := range children {
+		if trak.typ != "trak" {
+			continue
+		}
+		trakChildren, err := readBoxes(r, trak.offset, trak.offset+trak.size)
+		if err != nil {
+			return err
+		}
+		mdia, found := findBox(trakChildren, "mdia")
+		if !found {
+			continue
+		}
+		mdiaChildren, err := readBoxes(r, mdia.offset, mdia.offset+mdia.size)
+		if err != nil {
+			return err
+		}
+		if mdhd, found := findBox(mdiaChildren, "mdhd"); found {
+			return readMediaDates(r, mdhd, "MediaCreateDate", "MediaModifyDate", fields)
+		}
+	}
+	return nil
+}
+
+// readMediaDates reads the creation and modification dates of a mvhd or mdhd box
+func readMediaDates(r io.ReaderAt, b isoBox, createField string, modifyField string, fields map[string]interface{}) error {
+	h := make([]byte, 20)
+	if b.size < int64(len(h)) {
+		h = h[:b.size]
+	}
+	if _, err := r.ReadAt(h, b.offset); err != nil {
+		return fmt.Errorf("error while reading %q box: %w", b.typ, err)
+	}
+	c := boxCursor{p: h}
+	version := c.uint(1)
+	c.uint(3) // flags
+	size := 4
+	if version == 1 {
+		size = 8
+	}
+	created, modified := c.uint(size), c.uint(size)
+	if c.err != nil {
+		return fmt.Errorf("error while reading %q box: %w", b.typ, c.err)
+	}
+	fields[createField] = quickTimeDate(created)
+	fields[modifyField] = quickTimeDate(modified)
+	return nil
+}
+
+// quickTimeDate formats a date of a MP4 or MOV file like exiftool : UTC, the zero value
+// meaning that the date is unknown
+func quickTimeDate(secs uint64) string {
+	if secs == 0 || secs > 1<<40 {
+		return zeroDateTime
+	}
+	return time.Unix(quickTimeEpoch.Unix()+int64(secs), 0).UTC().Format(exifDateTime)
+}
@@ -0,0 +1,247 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// stateFolder is the folder, in the output folder, where the dispatcher stores its state
+	stateFolder      = ".dispatcher"
+	journalsFolder   = "journals"
+	quarantineFolder = "quarantine"
+	journalExt       = ".jsonl"
+	undoneExt        = ".undone"
+
+	journalIDFormat = "20060102T150405.000000000"
+
+	// JournalQuarantine is the operation recorded for a live video moved to quarantine
+	JournalQuarantine = "quarantine"
+	// JournalDrop is the operation recorded for a source removed because an identical file
+	// already existed in the output folder
+	JournalDrop = "drop"
+)
+
+// JournalEntry is an operation recorded in a journal. Op is a transfer mode,
+// JournalQuarantine or JournalDrop.
+type JournalEntry struct {
+	Op   string `json:"op"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Journal records the operations of a dispatch in the output folder so that it can be
+// undone
+type Journal struct {
+	mutex        sync.Mutex
+	outputFolder string
+	id           string
+	file         *os.File
+	enc          *json.Encoder
+	recorded     bool
+}
+
+// OpenJournal creates a new journal in outputFolder
+func OpenJournal(outputFolder string) (*Journal, error) {
+	dir := filepath.Join(outputFolder, stateFolder, journalsFolder)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, fmt.Errorf("error while creating journal folder: %w", err)
+	}
+	id := time.Now().Format(journalIDFormat)
+	f, err := os.OpenFile(filepath.Join(dir, id+journalExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating journal: %w", err)
+	}
+	return &Journal{outputFolder: outputFolder, id: id, file: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (j *Journal) record(op, from, to string) error {
+	absFrom, err := filepath.Abs(from)
+	if err != nil {
+		return err
+	}
+	absTo, err := filepath.Abs(to)
+	if err != nil {
+		return err
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.recorded = true
+	return j.enc.Encode(JournalEntry{Op: op, From: absFrom, To: absTo})
+}
+
+// Close closes the journal, it is removed if nothing has been recorded so that undo reverts
+// the last dispatch that did something
+func (j *Journal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if !j.recorded {
+		j.file.Close()
+		return os.Remove(j.file.Name())
+	}
+	if err := j.file.Sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
+
+// quarantinePath returns where a file of inputFolder is kept instead of being deleted
+func (j *Journal) quarantinePath(inputFolder, file string) (string, error) {
+	rel, err := filepath.Rel(inputFolder, file)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(j.outputFolder, stateFolder, quarantineFolder, j.id, rel), nil
+}
+
+// QuarantineLiveVideos moves the videos associated to live pictures contained in dir to the
+// quarantine folder of the journal, so that they can be restored by Undo, and returns how
+// many have been moved. The videos whose picture stays in dir (see ListLiveVideos) are kept,
+// it returns how many there are as well.
+func (dd *DateDispatcher) QuarantineLiveVideos(dir string, j *Journal) (int, int, error) {
+	videos, kept, err := dd.ListLiveVideos(dir, j.outputFolder)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, movFile := range kept {
+		log.Info().Str(fileLogField, movFile).Msgf("live video kept, its picture is not moved")
+	}
+	moved := 0
+	for _, movFile := range videos {
+		l := log.With().Str(fileLogField, movFile).Logger()
+		to, err := j.quarantinePath(dir, movFile)
+		if err != nil {
+			l.Warn().Msgf("error while computing quarantine path: %v", err)
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(to), 0777); err != nil {
+			l.Warn().Msgf("error while creating quarantine folder: %v", err)
+			continue
+		}
+		if err = move(movFile, to); err != nil {
+			l.Warn().Msgf("error while moving file to quarantine: %v", err)
+			continue
+		}
+		if err = j.record(JournalQuarantine, movFile, to); err != nil {
+			l.Warn().Msgf("error while recording quarantine: %v", err)
+		}
+		moved++
+	}
+	return moved, len(kept), nil
+}
+
+// lastJournal returns the most recent journal of outputFolder that has not been undone yet,
+// empty journals (left by a dispatch that has been killed before doing anything) excepted
+func lastJournal(outputFolder string) (string, error) {
+	journals, err := filepath.Glob(filepath.Join(outputFolder, stateFolder, journalsFolder, "*"+journalExt))
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(journals)
+	for i := len(journals) - 1; i >= 0; i-- {
+		if info, err := os.Stat(journals[i]); err == nil && info.Size() > 0 {
+			return journals[i], nil
+		}
+	}
+	return "", fmt.Errorf("no journal to undo in %v", outputFolder)
+}
+
+func readJournal(path string) ([]JournalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries := []JournalEntry{}
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var e JournalEntry
+		if err := dec.Decode(&e); err != nil {
+			return entries, fmt.Errorf("error while reading journal %v: %w", path, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Undo replays, in reverse order, the last journal of outputFolder that has not been undone
+// yet and returns how many operations have been reverted
+func Undo(outputFolder string) (int, error) {
+	path, err := lastJournal(outputFolder)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := readJournal(path)
+	if err != nil {
+		return 0, err
+	}
+
+	root, err := filepath.Abs(outputFolder)
+	if err != nil {
+		return 0, fmt.Errorf("error while resolving %v: %w", outputFolder, err)
+	}
+	undone := 0
+	failures := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if err := undoEntry(e); err != nil {
+			log.Error().Str(fileLogField, e.To).Msgf("error while undoing %v: %v", e.Op, err)
+			failures++
+			continue
+		}
+		undone++
+		removeEmptyParents(filepath.Dir(e.To), root)
+	}
+	if failures > 0 {
+		return undone, fmt.Errorf("%v operation(s) of %v could not be undone", failures, path)
+	}
+	if err = os.Rename(path, path+undoneExt); err != nil {
+		return undone, fmt.Errorf("error while marking %v as undone: %w", path, err)
+	}
+	log.Info().Msgf("%v undone (%v operation(s))", strings.TrimSuffix(filepath.Base(path), journalExt), undone)
+	return undone, nil
+}
+
+// removeEmptyParents removes dir and its parents as long as they are empty, stopping at root
+func removeEmptyParents(dir string, root string) {
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+func undoEntry(e JournalEntry) error {
+	switch e.Op {
+	case TransferMove, JournalQuarantine:
+		if _, err := os.Lstat(e.From); err == nil {
+			return fmt.Errorf("%v already exists", e.From)
+		}
+		if err := os.MkdirAll(filepath.Dir(e.From), 0777); err != nil {
+			return err
+		}
+		return move(e.To, e.From)
+	case JournalDrop:
+		if _, err := os.Lstat(e.From); err == nil {
+			return fmt.Errorf("%v already exists", e.From)
+		}
+		if err := os.MkdirAll(filepath.Dir(e.From), 0777); err != nil {
+			return err
+		}
+		return copy(e.To, e.From)
+	case TransferCopy, TransferHardlink, TransferSymlink, TransferReflink:
+		return removeExisting(e.To)
+	default:
+		return fmt.Errorf("unknown journal operation: %v", e.Op)
+	}
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUndo(t *testing.T) {
+	tmpDir := t.TempDir()
+	inDir := filepath.Join(tmpDir, "in")
+	assert.Nil(t, os.MkdirAll(filepath.Join(inDir, "sub"), 0777))
+	outDir := filepath.Join(tmpDir, "out")
+	assert.Nil(t, os.MkdirAll(filepath.Join(outDir, "2019_04"), 0777))
+	jpgFile := filepath.Join(inDir, "sub", "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", jpgFile))
+	movFile := filepath.Join(inDir, "sub", "a.MOV")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", movFile))
+	dupFile := filepath.Join(inDir, "b.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", dupFile))
+	existingFile := filepath.Join(outDir, "2019_04", "b.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", existingFile))
+
+	j, err := OpenJournal(outDir)
+	assert.Nil(t, err)
+	c, err := NewDateDispatcher(OptJournal(j), OptCollisionPolicy(CollisionHash))
+	assert.Nil(t, err)
+	quarantined, kept, err := c.QuarantineLiveVideos(inDir, j)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, quarantined)
+	assert.Equal(t, 0, kept)
+	checkExist(t, movFile, false)
+
+	actionChan := make(chan moveAction, 2)
+	actionChan <- moveAction{from: jpgFile, to: filepath.Join("2019_04", "a.jpg")}
+	actionChan <- moveAction{from: dupFile, to: filepath.Join("2019_04", "b.jpg")}
+	close(actionChan)
+	ctx, cancel := context.WithCancel(context.TODO())
+	c.moveFiles(ctx, cancel, outDir, actionChan, nil, newDispatchStats())
+	assert.Nil(t, j.Close())
+	checkExist(t, jpgFile, false)
+	checkExist(t, dupFile, false)
+	checkExist(t, filepath.Join(outDir, "2019_04", "a.jpg"), true)
+
+	undone, err := Undo(outDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, undone)
+	checkExist(t, jpgFile, true)
+	checkExist(t, movFile, true)
+	checkExist(t, dupFile, true)
+	checkExist(t, existingFile, true)
+	checkExist(t, filepath.Join(outDir, "2019_04", "a.jpg"), false)
+
+	_, err = Undo(outDir)
+	assert.NotNil(t, err)
+}
+
+func TestUndoCopy(t *testing.T) {
+	tmpDir := t.TempDir()
+	inFile := filepath.Join(tmpDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", inFile))
+	outDir := filepath.Join(tmpDir, "out")
+
+	j, err := OpenJournal(outDir)
+	assert.Nil(t, err)
+	actionChan := make(chan moveAction, 1)
+	actionChan <- moveAction{from: inFile, to: filepath.Join("2019_04", "20190404_131804.jpg")}
+	close(actionChan)
+	c, err := NewDateDispatcher(OptJournal(j), OptTransferMode(TransferCopy))
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.TODO())
+	c.moveFiles(ctx, cancel, outDir, actionChan, nil, newDispatchStats())
+	assert.Nil(t, j.Close())
+
+	undone, err := Undo(outDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, undone)
+	checkExist(t, inFile, true)
+	checkExist(t, filepath.Join(outDir, "2019_04"), false)
+}
+
+func TestUndoSkipsEmptyJournals(t *testing.T) {
+	tmpDir := t.TempDir()
+	inFile := filepath.Join(tmpDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", inFile))
+	outDir := filepath.Join(tmpDir, "out")
+
+	j, err := OpenJournal(outDir)
+	assert.Nil(t, err)
+	actionChan := make(chan moveAction, 1)
+	actionChan <- moveAction{from: inFile, to: filepath.Join("2019_04", "a.jpg")}
+	close(actionChan)
+	c, err := NewDateDispatcher(OptJournal(j))
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	c.moveFiles(ctx, cancel, outDir, actionChan, nil, newDispatchStats())
+	assert.Nil(t, j.Close())
+
+	// a dispatch that did nothing
+	j, err = OpenJournal(outDir)
+	assert.Nil(t, err)
+	assert.Nil(t, j.Close())
+	checkExist(t, j.file.Name(), false)
+	// a dispatch killed before doing anything
+	j, err = OpenJournal(outDir)
+	assert.Nil(t, err)
+	assert.Nil(t, j.file.Close())
+
+	undone, err := Undo(outDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, undone)
+	checkExist(t, inFile, true)
+}
+
+func TestUndoWithoutJournal(t *testing.T) {
+	_, err := Undo(t.TempDir())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+// jsonLines is a file made of an optional JSON header line followed by one JSON line per
+// entry, used to store the state of the dispatcher (checkpoint, metadata cache). Entries are
+// appended as they are recorded, the file is rewritten without its outdated entries when it
+// is opened. It is not safe for concurrent use.
+type jsonLines struct {
+	name string
+	path string
+	file *os.File
+	enc  *json.Encoder
+}
+
+// load reads the file : header, if not nil, is decoded from the first line and the entries
+// are only decoded (one per call to decode) if valid returns true. A missing file has no
+// entry.
+func (j *jsonLines) load(header interface{}, valid func() bool, decode func(dec *json.Decoder) error) error {
+	f, err := os.Open(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error while opening %v: %w", j.name, err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	if header != nil {
+		if err := dec.Decode(header); err != nil {
+			log.Warn().Msgf("error while reading %v header, %v discarded: %v", j.name, j.name, err)
+			return nil
+		}
+		if !valid() {
+			return nil
+		}
+	}
+	for dec.More() {
+		if err := decode(dec); err != nil {
+			// the last entry may have been truncated by an interruption
+			log.Warn().Msgf("error while reading %v, ignoring the rest of it: %v", j.name, err)
+			break
+		}
+	}
+	return nil
+}
+
+// rewrite atomically replaces the file with header, if not nil, followed by the entries
+// written by encode
+func (j *jsonLines) rewrite(header interface{}, encode func(enc *json.Encoder) error) error {
+	if err := os.MkdirAll(filepath.Dir(j.path), 0777); err != nil {
+		return fmt.Errorf("error while creating %v folder: %w", j.name, err)
+	}
+	tmp := j.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("error while writing %v: %w", j.name, err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	if header != nil {
+		err = enc.Encode(header)
+	}
+	if err == nil {
+		err = encode(enc)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, j.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error while writing %v: %w", j.name, err)
+	}
+	return nil
+}
+
+// openAppend opens the file so that entries can be appended
+func (j *jsonLines) openAppend() error {
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("error while opening %v: %w", j.name, err)
+	}
+	j.file = f
+	j.enc = json.NewEncoder(f)
+	return nil
+}
+
+// append writes an entry, nothing is written if the file has not been opened for appending
+func (j *jsonLines) append(entry interface{}) error {
+	if j.enc == nil {
+		return nil
+	}
+	return j.enc.Encode(entry)
+}
+
+func (j *jsonLines) close() error {
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// remove closes and removes the file
+func (j *jsonLines) remove() error {
+	if err := j.close(); err != nil {
+		return err
+	}
+	return os.Remove(j.path)
+}
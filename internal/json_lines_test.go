@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONLines(t *testing.T) {
+	type header struct {
+		Version int `json:"version"`
+	}
+	path := filepath.Join(t.TempDir(), "state", "store.jsonl")
+	load := func(version int) []string {
+		var h header
+		entries := []string{}
+		j := jsonLines{name: "store", path: path}
+		err := j.load(&h, func() bool { return h.Version == version }, func(dec *json.Decoder) error {
+			var e string
+			if err := dec.Decode(&e); err != nil {
+				return err
+			}
+			entries = append(entries, e)
+			return nil
+		})
+		assert.Nil(t, err)
+		return entries
+	}
+
+	assert.Empty(t, load(1))
+	j := jsonLines{name: "store", path: path}
+	assert.Nil(t, j.rewrite(header{Version: 1}, func(enc *json.Encoder) error { return enc.Encode("a") }))
+	assert.Nil(t, j.openAppend())
+	assert.Nil(t, j.append("b"))
+	assert.Nil(t, j.close())
+	assert.Equal(t, []string{"a", "b"}, load(1))
+	// the entries of another version are discarded
+	assert.Empty(t, load(2))
+
+	// the truncated entry is ignored
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	assert.Nil(t, err)
+	_, err = f.WriteString(`"c`)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	assert.Equal(t, []string{"a", "b"}, load(1))
+
+	// nothing is written until the file is opened for appending
+	j = jsonLines{name: "store", path: path}
+	assert.Nil(t, j.append("d"))
+	assert.Nil(t, j.close())
+
+	assert.Nil(t, j.remove())
+	checkExist(t, path, false)
+}
@@ -19,20 +19,71 @@ func isJpeg(f string) bool {
 	return jpgRe.MatchString(ext)
 }
 
-func RemoveLiveVideos(dir string) error {
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+// liveVideo is the video associated to a live picture
+type liveVideo struct {
+	picture string
+	video   string
+}
+
+// ListLiveVideos returns the videos associated to live pictures contained in dir : the
+// ones that are removed, whose picture is moved, and the ones that are kept, whose picture
+// is transferred with another mode (see OptTransferMode and Rule) and stays in dir. The
+// files and folders ignored by the dispatch (state folder, outputFolder if it sits inside
+// dir, globs, hidden files, max depth) are ignored as well, for the pictures and for the
+// videos.
+func (dd *DateDispatcher) ListLiveVideos(dir string, outputFolder string) ([]string, []string, error) {
+	lvs, err := listLiveVideos(dd.newFileFilter(dir, outputFolder))
+	if err != nil {
+		return nil, nil, err
+	}
+	removed, kept := []string{}, []string{}
+	for _, lv := range lvs {
+		if mode, known := dd.transferModeWithoutMetadata(lv.picture); known && mode == TransferMove {
+			removed = append(removed, lv.video)
+		} else {
+			kept = append(kept, lv.video)
+		}
+	}
+	return removed, kept, nil
+}
+
+func listLiveVideos(filter fileFilter) ([]liveVideo, error) {
+	lvs := []liveVideo{}
+	err := filepath.Walk(filter.inputFolder, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return fmt.Errorf("error when browsing file %v: %v", path, err)
 		}
+		if skip, skipErr := filter.skip(path, info); skip {
+			return skipErr
+		}
 		if !info.IsDir() && isJpeg(path) {
 			movFile := fmt.Sprintf("%v%v", path[0:strings.LastIndex(path, ".")], liveExt)
-			if _, err := os.Stat(movFile); err == nil {
-				if err = os.Remove(movFile); err != nil {
-					log.Warn().Str(fileLogField, movFile).Msgf("error while removing file: %v", err)
+			if movInfo, err := os.Stat(movFile); err == nil {
+				if skip, _ := filter.skip(movFile, movInfo); !skip {
+					lvs = append(lvs, liveVideo{picture: path, video: movFile})
 				}
 			}
 		}
 		return nil
 	})
-	return err
+	return lvs, err
+}
+
+// RemoveLiveVideos removes the videos associated to live pictures contained in dir and
+// returns how many have been removed
+func RemoveLiveVideos(dir string) (int, error) {
+	lvs, err := listLiveVideos((&DateDispatcher{}).newFileFilter(dir, ""))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, lv := range lvs {
+		movFile := lv.video
+		if err = os.Remove(movFile); err != nil {
+			log.Warn().Str(fileLogField, movFile).Msgf("error while removing file: %v", err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
 }
@@ -3,6 +3,7 @@ package internal
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -50,7 +51,9 @@ func TestRemoveLiveVideos(t *testing.T) {
 	singleMovFile := filepath.Join(tmpDir, "e.MOV")
 	copy("../testdata/input/20190404_131804.jpg", singleMovFile)
 
-	assert.Nil(t, RemoveLiveVideos(tmpDir))
+	removed, err := RemoveLiveVideos(tmpDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, removed)
 
 	checkExist(t, liveJpgFile, true)
 	checkExist(t, liveMovFile, false)
@@ -61,3 +64,101 @@ func TestRemoveLiveVideos(t *testing.T) {
 	checkExist(t, singleMovFile, true)
 
 }
+
+func TestListLiveVideos(t *testing.T) {
+	tmpDir := t.TempDir()
+	liveJpgFile := filepath.Join(tmpDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", liveJpgFile))
+	liveMovFile := filepath.Join(tmpDir, "a.MOV")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", liveMovFile))
+	singleMovFile := filepath.Join(tmpDir, "e.MOV")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", singleMovFile))
+
+	c, err := NewDateDispatcher()
+	assert.Nil(t, err)
+	videos, _, err := c.ListLiveVideos(tmpDir, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{liveMovFile}, videos)
+	checkExist(t, liveMovFile, true)
+}
+
+func TestListLiveVideosSkipsOutputFolder(t *testing.T) {
+	tmpDir := t.TempDir()
+	outDir := filepath.Join(tmpDir, "out", "2019_04")
+	assert.Nil(t, os.MkdirAll(outDir, 0777))
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", filepath.Join(outDir, "a.jpg")))
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", filepath.Join(outDir, "a.MOV")))
+
+	c, err := NewDateDispatcher()
+	assert.Nil(t, err)
+	videos, _, err := c.ListLiveVideos(tmpDir, filepath.Join(tmpDir, "out"))
+	assert.Nil(t, err)
+	assert.Empty(t, videos)
+}
+
+func TestListLiveVideosFilters(t *testing.T) {
+	tmpDir := t.TempDir()
+	live := func(path ...string) string {
+		jpg := filepath.Join(append([]string{tmpDir}, path...)...)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(jpg), 0777))
+		assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", jpg))
+		mov := strings.TrimSuffix(jpg, filepath.Ext(jpg)) + liveExt
+		assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", mov))
+		return mov
+	}
+	kept := live("a.jpg")
+	live("excluded", "b.jpg")
+	live(".hidden", "c.jpg")
+	live("sub", "deep", "d.jpg")
+	live("e.jpeg")
+	sub := live("sub", "f.jpg")
+
+	c, err := NewDateDispatcher(OptExcludeGlobs([]string{"excluded", "*.jpeg"}), OptSkipHidden(), OptMaxDepth(2))
+	assert.Nil(t, err)
+	videos, _, err := c.ListLiveVideos(tmpDir, "")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{kept, sub}, videos)
+
+	// the videos themselves are filtered
+	c, err = NewDateDispatcher(OptExcludeGlobs([]string{"*.mov"}))
+	assert.Nil(t, err)
+	videos, _, err = c.ListLiveVideos(tmpDir, "")
+	assert.Nil(t, err)
+	assert.Empty(t, videos)
+}
+
+func TestListLiveVideosTransferModes(t *testing.T) {
+	tmpDir := t.TempDir()
+	live := func(name string) string {
+		jpg := filepath.Join(tmpDir, name)
+		assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", jpg))
+		mov := strings.TrimSuffix(jpg, filepath.Ext(jpg)) + liveExt
+		assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", mov))
+		return mov
+	}
+	moved := live("a.jpg")
+	copied := live("b.jpeg")
+
+	var tcs = []struct {
+		tcID       string
+		opts       []func(*DateDispatcher) error
+		expRemoved []string
+		expKept    []string
+	}{
+		{"move", nil, []string{moved, copied}, []string{}},
+		{"copy", []func(*DateDispatcher) error{OptTransferMode(TransferCopy)}, []string{}, []string{moved, copied}},
+		{"copyRule", []func(*DateDispatcher) error{OptRules([]Rule{{Extensions: []string{"jpeg"}, TransferMode: TransferCopy}})}, []string{moved}, []string{copied}},
+		{"moveRule", []func(*DateDispatcher) error{OptTransferMode(TransferCopy), OptRules([]Rule{{Extensions: []string{"jpg"}, TransferMode: TransferMove}})}, []string{moved}, []string{copied}},
+		{"mimeTypeRule", []func(*DateDispatcher) error{OptRules([]Rule{{MimeTypes: []string{"image/jpeg"}, TransferMode: TransferCopy}})}, []string{}, []string{moved, copied}},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			c, err := NewDateDispatcher(tc.opts...)
+			assert.Nil(t, err)
+			removed, kept, err := c.ListLiveVideos(tmpDir, "")
+			assert.Nil(t, err)
+			assert.ElementsMatch(t, tc.expRemoved, removed)
+			assert.ElementsMatch(t, tc.expKept, kept)
+		})
+	}
+}
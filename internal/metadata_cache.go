@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// metadataCacheHeader is the first line of the metadata cache : entries only hold Tags
+// (every tag if AllTags), they are discarded when the dispatcher needs other tags
+type metadataCacheHeader struct {
+	Tags    []string `json:"tags"`
+	AllTags bool     `json:"allTags"`
+}
+
+// metadataCacheEntry holds the tags of a file, it is valid as long as the file has the
+// same size and modification time
+type metadataCacheEntry struct {
+	File    string                 `json:"file"`
+	Size    int64                  `json:"size"`
+	ModTime time.Time              `json:"modTime"`
+	Fields  map[string]interface{} `json:"fields"`
+}
+
+// metadataCache stores on disk the tags extracted by exiftool, so that files are not
+// extracted again by the next dispatches. Dates are resolved from the cached tags, so that
+// configuration changes are taken into account. A nil cache stores nothing, a read-only
+// cache (dry runs) doesn't store the extracted tags.
+type metadataCache struct {
+	mutex   sync.Mutex
+	store   jsonLines
+	header  metadataCacheHeader
+	entries map[string]metadataCacheEntry
+}
+
+// OptMetadataCache enables the metadata cache, stored in path
+func OptMetadataCache(path string) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		if path == "" {
+			return fmt.Errorf("empty metadata cache path")
+		}
+		c.metadataCachePath = path
+		return nil
+	}
+}
+
+// InvalidateMetadataCache removes the metadata cache stored in path
+func InvalidateMetadataCache(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error while invalidating metadata cache: %w", err)
+	}
+	return nil
+}
+
+// openMetadataCache loads the cache stored in path, it is left untouched if readOnly
+func openMetadataCache(path string, tags []string, allTags bool, readOnly bool) (*metadataCache, error) {
+	c := metadataCache{
+		store:   jsonLines{name: "metadata cache", path: path},
+		header:  metadataCacheHeader{Tags: tags, AllTags: allTags},
+		entries: make(map[string]metadataCacheEntry),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	if readOnly {
+		return &c, nil
+	}
+	if err := c.compact(); err != nil {
+		return nil, err
+	}
+	if err := c.store.openAppend(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *metadataCache) load() error {
+	var h metadataCacheHeader
+	valid := func() bool {
+		if h.AllTags != c.header.AllTags || (!h.AllTags && !reflect.DeepEqual(h.Tags, c.header.Tags)) {
+			log.Info().Msgf("metadata cache built for other tags, cache invalidated")
+			return false
+		}
+		return true
+	}
+	err := c.store.load(&h, valid, func(dec *json.Decoder) error {
+		var e metadataCacheEntry
+		if err := dec.Decode(&e); err != nil {
+			return err
+		}
+		c.entries[e.File] = e
+		return nil
+	})
+	log.Debug().Msgf("%v file(s) in metadata cache", len(c.entries))
+	return err
+}
+
+// compact rewrites the cache without its outdated entries and the entries of the files
+// that don't exist anymore
+func (c *metadataCache) compact() error {
+	for k, e := range c.entries {
+		if _, err := os.Stat(e.File); err != nil {
+			delete(c.entries, k)
+		}
+	}
+	return c.store.rewrite(c.header, func(enc *json.Encoder) error {
+		for _, e := range c.entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// lookup returns the cached tags of file if the file has not changed since
+func (c *metadataCache) lookup(file string) (map[string]interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, false
+	}
+	c.mutex.Lock()
+	e, found := c.entries[abs]
+	c.mutex.Unlock()
+	if !found {
+		return nil, false
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.Size() != e.Size || !info.ModTime().Equal(e.ModTime) {
+		return nil, false
+	}
+	return e.Fields, true
+}
+
+// record caches the relevant tags of file
+func (c *metadataCache) record(file string, fields map[string]interface{}) error {
+	if c == nil {
+		return nil
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	kept := fields
+	if !c.header.AllTags {
+		kept = make(map[string]interface{}, len(c.header.Tags))
+		for _, t := range c.header.Tags {
+			if v, found := fields[t]; found {
+				kept[t] = v
+			}
+		}
+	}
+	e := metadataCacheEntry{File: abs, Size: info.Size(), ModTime: info.ModTime(), Fields: kept}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[abs] = e
+	return c.store.append(e)
+}
+
+func (c *metadataCache) close() error {
+	if c == nil {
+		return nil
+	}
+	return c.store.close()
+}
@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetadataCache(t *testing.T) {
+	tmpDir := t.TempDir()
+	cachePath := filepath.Join(tmpDir, "cache", "metadata.jsonl")
+	cached := filepath.Join(tmpDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", cached))
+	modified := filepath.Join(tmpDir, "b.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", modified))
+	tags := []string{"CreateDate", "Make"}
+	fields := map[string]interface{}{"CreateDate": "2019:04:04 13:18:04", "ISO": float64(100)}
+
+	mc, err := openMetadataCache(cachePath, tags, false, false)
+	assert.Nil(t, err)
+	assert.Nil(t, mc.record(cached, fields))
+	assert.Nil(t, mc.record(modified, fields))
+	assert.Nil(t, mc.close())
+	mtime := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.Local)
+	assert.Nil(t, os.Chtimes(modified, mtime, mtime))
+
+	mc, err = openMetadataCache(cachePath, tags, false, false)
+	assert.Nil(t, err)
+	got, found := mc.lookup(cached)
+	assert.True(t, found)
+	assert.Equal(t, map[string]interface{}{"CreateDate": "2019:04:04 13:18:04"}, got)
+	_, found = mc.lookup(modified)
+	assert.False(t, found)
+	_, found = mc.lookup(filepath.Join(tmpDir, "missing.jpg"))
+	assert.False(t, found)
+	assert.Nil(t, mc.close())
+
+	// other tags invalidate the cache
+	mc, err = openMetadataCache(cachePath, []string{"CreateDate"}, false, false)
+	assert.Nil(t, err)
+	_, found = mc.lookup(cached)
+	assert.False(t, found)
+	assert.Nil(t, mc.close())
+
+	assert.Nil(t, InvalidateMetadataCache(cachePath))
+	checkExist(t, cachePath, false)
+	assert.Nil(t, InvalidateMetadataCache(cachePath))
+}
+
+func TestMetadataCacheAllTags(t *testing.T) {
+	tmpDir := t.TempDir()
+	cachePath := filepath.Join(tmpDir, "metadata.jsonl")
+	file := filepath.Join(tmpDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", file))
+	fields := map[string]interface{}{"CreateDate": "2019:04:04 13:18:04", "ISO": float64(100)}
+
+	mc, err := openMetadataCache(cachePath, []string{"CreateDate"}, true, false)
+	assert.Nil(t, err)
+	assert.Nil(t, mc.record(file, fields))
+	assert.Nil(t, mc.close())
+
+	mc, err = openMetadataCache(cachePath, []string{"CreateDate"}, true, false)
+	assert.Nil(t, err)
+	got, found := mc.lookup(file)
+	assert.True(t, found)
+	assert.Equal(t, fields, got)
+	assert.Nil(t, mc.close())
+}
+
+func TestMetadataCacheCompact(t *testing.T) {
+	tmpDir := t.TempDir()
+	cachePath := filepath.Join(tmpDir, "metadata.jsonl")
+	kept := filepath.Join(tmpDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", kept))
+	removed := filepath.Join(tmpDir, "b.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", removed))
+	fields := map[string]interface{}{"CreateDate": "2019:04:04 13:18:04"}
+
+	mc, err := openMetadataCache(cachePath, []string{"CreateDate"}, false, false)
+	assert.Nil(t, err)
+	assert.Nil(t, mc.record(kept, fields))
+	assert.Nil(t, mc.record(removed, fields))
+	assert.Nil(t, mc.record(kept, fields))
+	assert.Nil(t, mc.close())
+	assert.Nil(t, os.Remove(removed))
+
+	mc, err = openMetadataCache(cachePath, []string{"CreateDate"}, false, false)
+	assert.Nil(t, err)
+	assert.Nil(t, mc.close())
+	b, err := os.ReadFile(cachePath)
+	assert.Nil(t, err)
+	// the header and the entry of the remaining file
+	assert.Equal(t, 2, strings.Count(string(b), "\n"))
+	assert.NotContains(t, string(b), "b.jpg")
+}
+
+func TestMetadataCacheReadOnly(t *testing.T) {
+	tmpDir := t.TempDir()
+	cachePath := filepath.Join(tmpDir, "cache", "metadata.jsonl")
+	file := filepath.Join(tmpDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", file))
+	other := filepath.Join(tmpDir, "b.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", other))
+	fields := map[string]interface{}{"CreateDate": "2019:04:04 13:18:04"}
+
+	// nothing is created
+	mc, err := openMetadataCache(cachePath, []string{"CreateDate"}, false, true)
+	assert.Nil(t, err)
+	assert.Nil(t, mc.record(file, fields))
+	assert.Nil(t, mc.close())
+	checkExist(t, filepath.Dir(cachePath), false)
+
+	mc, err = openMetadataCache(cachePath, []string{"CreateDate"}, false, false)
+	assert.Nil(t, err)
+	assert.Nil(t, mc.record(file, fields))
+	assert.Nil(t, mc.close())
+	before, err := os.ReadFile(cachePath)
+	assert.Nil(t, err)
+
+	// nothing is modified, even for other tags
+	for _, tags := range [][]string{{"CreateDate"}, {"Make"}} {
+		mc, err = openMetadataCache(cachePath, tags, false, true)
+		assert.Nil(t, err)
+		_, found := mc.lookup(file)
+		assert.Equal(t, tags[0] == "CreateDate", found)
+		assert.Nil(t, mc.record(other, fields))
+		assert.Nil(t, mc.close())
+		after, err := os.ReadFile(cachePath)
+		assert.Nil(t, err)
+		assert.Equal(t, before, after)
+	}
+}
+
+func TestPlanMetadataCacheReadOnly(t *testing.T) {
+	tmpDir := t.TempDir()
+	cachePath := filepath.Join(tmpDir, "metadata.jsonl")
+	fake := NewFakeExtractor()
+	c, err := NewDateDispatcher(
+		OptOrderedDateFields([]DateField{{Field: "CreateDate", Pattern: "2006:01:02 15:04:05"}}),
+		OptMetadataCache(cachePath),
+		OptExtractorFactory(fake.Factory()),
+	)
+	assert.Nil(t, err)
+	_, err = c.Plan("../testdata/input", filepath.Join(tmpDir, "out"))
+	assert.Nil(t, err)
+	checkExist(t, cachePath, false)
+}
+
+func TestMetadataCacheTruncated(t *testing.T) {
+	tmpDir := t.TempDir()
+	cachePath := filepath.Join(tmpDir, "metadata.jsonl")
+	file := filepath.Join(tmpDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", file))
+
+	mc, err := openMetadataCache(cachePath, []string{"CreateDate"}, false, false)
+	assert.Nil(t, err)
+	assert.Nil(t, mc.record(file, map[string]interface{}{"CreateDate": "2019:04:04 13:18:04"}))
+	assert.Nil(t, mc.close())
+	f, err := os.OpenFile(cachePath, os.O_WRONLY|os.O_APPEND, 0666)
+	assert.Nil(t, err)
+	_, err = f.WriteString(`{"file":"/tmp/trunc`)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	mc, err = openMetadataCache(cachePath, []string{"CreateDate"}, false, false)
+	assert.Nil(t, err)
+	_, found := mc.lookup(file)
+	assert.True(t, found)
+	assert.Nil(t, mc.close())
+}
+
+func TestNilMetadataCache(t *testing.T) {
+	var mc *metadataCache
+	_, found := mc.lookup("a.jpg")
+	assert.False(t, found)
+	assert.Nil(t, mc.record("a.jpg", nil))
+	assert.Nil(t, mc.close())
+}
+
+func TestGetMoveActionsMetadataCache(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", file))
+	cachePath := filepath.Join(tmpDir, "metadata.jsonl")
+
+	fake := NewFakeExtractor()
+	c, err := NewDateDispatcher(
+		OptThreadCount(1),
+		OptOrderedDateFields([]DateField{{Field: "CreateDate", Pattern: "2006:01:02 15:04:05"}}),
+		OptMetadataCache(cachePath),
+		OptExtractorFactory(fake.Factory()),
+	)
+	assert.Nil(t, err)
+	tags, all := c.relevantTags()
+	mc, err := openMetadataCache(cachePath, tags, all, false)
+	assert.Nil(t, err)
+	defer mc.close()
+	assert.Nil(t, mc.record(file, map[string]interface{}{"CreateDate": "2001:01:01 10:00:00"}))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	fileChan := make(chan string, 1)
+	fileChan <- file
+	close(fileChan)
+	actionChan := make(chan moveAction, 1)
+	stats := newDispatchStats()
+	assert.Nil(t, c.getMoveActions(ctx, cancel, fileChan, actionChan, nil, mc, stats))
+
+	ma := <-actionChan
+	assert.Equal(t, filepath.Join("2001_01", "a.jpg"), ma.to)
+	assert.Equal(t, 1, stats.buildReport().CachedFiles)
+	assert.Empty(t, fake.Extracted())
+}
+
+func TestOptMetadataCacheEmpty(t *testing.T) {
+	_, err := NewDateDispatcher(OptMetadataCache(""))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/barasher/go-exiftool"
+)
+
+const (
+	ExtractorExiftool = "exiftool"
+	ExtractorNative   = "native"
+
+	defaultExtractor = ExtractorExiftool
+)
+
+// MetadataExtractor extracts the metadata of files, keyed by exiftool tag names
+// ("DateTimeOriginal", "MIMEType", ...). Each worker has its own extractor, implementations
+// don't have to be safe for concurrent use.
+type MetadataExtractor interface {
+	// ExtractMetadata returns the metadata of files, in the same order, errors are reported
+	// per file
+	ExtractMetadata(files ...string) []exiftool.FileMetadata
+	Close() error
+}
+
+// ExtractorFactory creates the extractor of a worker. tags are the tags the dispatcher
+// needs (every tag if empty), extractors may return more.
+type ExtractorFactory func(tags []string) (MetadataExtractor, error)
+
+// OptMetadataExtractor defines how metadata are extracted : ExtractorExiftool (default,
+// requires exiftool) or ExtractorNative (EXIF of JPEG, TIFF and HEIC files, creation dates
+// of MP4 and MOV files, without any external dependency)
+func OptMetadataExtractor(kind string) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		switch kind {
+		case ExtractorExiftool, ExtractorNative:
+			c.extractor = kind
+			return nil
+		default:
+			return fmt.Errorf("unsupported metadata extractor: %v", kind)
+		}
+	}
+}
+
+// OptExtractorFactory extracts metadata with the extractors created by factory (one per
+// worker), it takes precedence over OptMetadataExtractor. NewFakeExtractor provides one for
+// tests.
+func OptExtractorFactory(factory ExtractorFactory) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		if factory == nil {
+			return fmt.Errorf("nil extractor factory")
+		}
+		c.extractorFactory = factory
+		return nil
+	}
+}
+
+func (dd *DateDispatcher) newExtractorFactory() ExtractorFactory {
+	if dd.extractorFactory != nil {
+		return dd.extractorFactory
+	}
+	if dd.extractor == ExtractorNative {
+		return func(tags []string) (MetadataExtractor, error) {
+			return nativeExtractor{}, nil
+		}
+	}
+	return func(tags []string) (MetadataExtractor, error) {
+		return newExiftoolExtractor(dd.exiftoolPath, tags)
+	}
+}
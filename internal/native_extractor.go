@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/barasher/go-exiftool"
+)
+
+const (
+	fileNameField = "FileName"
+	fileTypeField = "FileType"
+)
+
+// nativeExtractor reads metadata without exiftool : EXIF tags of JPEG, TIFF (and TIFF based
+// raw formats) and HEIC/HEIF files, creation dates of MP4 and MOV files. Tags are named
+// after exiftool so that the configuration doesn't depend on the extractor, other files
+// only get their name.
+type nativeExtractor struct{}
+
+func (nativeExtractor) ExtractMetadata(files ...string) []exiftool.FileMetadata {
+	fms := make([]exiftool.FileMetadata, len(files))
+	for i, f := range files {
+		fms[i].File = f
+		fms[i].Fields, fms[i].Err = readNativeMetadata(f)
+	}
+	return fms
+}
+
+func (nativeExtractor) Close() error {
+	return nil
+}
+
+func readNativeMetadata(file string) (map[string]interface{}, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, exiftool.ErrNotExist
+		}
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	magic := make([]byte, 8)
+	n, err := f.ReadAt(magic, 0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error while reading file: %w", err)
+	}
+	magic, err = magic[:n], nil
+
+	fields := map[string]interface{}{
+		sourceFileField: file,
+		fileNameField:   filepath.Base(file),
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte{0xff, 0xd8}):
+		fields[fileTypeField] = "JPEG"
+		fields[mimeTypeField] = "image/jpeg"
+		err = readJPEG(f, info.Size(), fields)
+	case bytes.HasPrefix(magic, []byte("II*\x00")), bytes.HasPrefix(magic, []byte("MM\x00*")):
+		fields[fileTypeField] = "TIFF"
+		fields[mimeTypeField] = "image/tiff"
+		err = readTIFF(f, 0, fields)
+	case len(magic) == 8 && isISOBox(magic[4:]):
+		err = readISOBMFF(f, info.Size(), fields)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while reading %v metadata: %w", fields[fileTypeField], err)
+	}
+	return fields, nil
+}
@@ -0,0 +1,228 @@
+package internal
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/barasher/go-exiftool"
+	"github.com/stretchr/testify/assert"
+)
+
+type tiffTag struct {
+	tag   uint16
+	value string
+}
+
+// buildTIFF builds a TIFF structure holding ASCII tags in IFD0 and in the Exif IFD
+func buildTIFF(order binary.ByteOrder, ifd0 []tiffTag, exifIFD []tiffTag) []byte {
+	ifdSize := func(n int) int { return 2 + 12*n + 4 }
+	ifd0Off := 8
+	exifOff := ifd0Off + ifdSize(len(ifd0)+1)
+	b := make([]byte, exifOff+ifdSize(len(exifIFD)))
+	b[0], b[1] = 'M', 'M'
+	if order == binary.LittleEndian {
+		b[0], b[1] = 'I', 'I'
+	}
+	order.PutUint16(b[2:], 42)
+	order.PutUint32(b[4:], uint32(ifd0Off))
+	writeIFD := func(off int, tags []tiffTag, pointer bool) {
+		n := len(tags)
+		if pointer {
+			n++
+		}
+		order.PutUint16(b[off:], uint16(n))
+		e := off + 2
+		for _, t := range tags {
+			v := append([]byte(t.value), 0)
+			order.PutUint16(b[e:], t.tag)
+			order.PutUint16(b[e+2:], tiffASCII)
+			order.PutUint32(b[e+4:], uint32(len(v)))
+			if len(v) <= 4 {
+				for i, c := range v {
+					b[e+8+i] = c
+				}
+			} else {
+				order.PutUint32(b[e+8:], uint32(len(b)))
+				b = append(b, v...)
+			}
+			e += 12
+		}
+		if pointer {
+			order.PutUint16(b[e:], exifIFDPointer)
+			order.PutUint16(b[e+2:], tiffLong)
+			order.PutUint32(b[e+4:], 1)
+			order.PutUint32(b[e+8:], uint32(exifOff))
+		}
+	}
+	writeIFD(ifd0Off, ifd0, true)
+	writeIFD(exifOff, exifIFD, false)
+	return b
+}
+
+func isoBoxBytes(typ string, payloads ...[]byte) []byte {
+	b := append(make([]byte, 4), typ...)
+	for _, p := range payloads {
+		b = append(b, p...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	return b
+}
+
+func beUint(size int, v uint64) []byte {
+	b := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	return b
+}
+
+func join(parts ...[]byte) []byte {
+	b := []byte{}
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// buildHEIC builds a HEIC file whose Exif item is stored in an idat box (iloc version 1,
+// construction method 1) or in the mdat box (iloc version 0)
+func buildHEIC(tiff []byte, idat bool) []byte {
+	exif := join(beUint(4, 6), []byte("Exif\x00\x00"), tiff)
+	ftyp := isoBoxBytes("ftyp", []byte("heic"), beUint(4, 0), []byte("mif1heic"))
+	infe := func(id uint64, typ string) []byte {
+		return isoBoxBytes("infe", []byte{2, 0, 0, 0}, beUint(2, id), beUint(2, 0), []byte(typ), []byte{0})
+	}
+	iinf := isoBoxBytes("iinf", []byte{0, 0, 0, 0}, beUint(2, 2), infe(1, "hvc1"), infe(2, "Exif"))
+	meta := func(offset uint64) []byte {
+		var iloc []byte
+		if idat {
+			iloc = isoBoxBytes("iloc", []byte{1, 0, 0, 0, 0x44, 0x00}, beUint(2, 1),
+				beUint(2, 2), beUint(2, 1), beUint(2, 0), beUint(2, 1), beUint(4, offset), beUint(4, uint64(len(exif))))
+			return isoBoxBytes("meta", []byte{0, 0, 0, 0}, iinf, iloc, isoBoxBytes("idat", exif))
+		}
+		iloc = isoBoxBytes("iloc", []byte{0, 0, 0, 0, 0x44, 0x00}, beUint(2, 1),
+			beUint(2, 2), beUint(2, 0), beUint(2, 1), beUint(4, offset), beUint(4, uint64(len(exif))))
+		return isoBoxBytes("meta", []byte{0, 0, 0, 0}, iinf, iloc)
+	}
+	if idat {
+		return join(ftyp, meta(0))
+	}
+	offset := uint64(len(ftyp) + len(meta(0)) + 8)
+	return join(ftyp, meta(offset), isoBoxBytes("mdat", exif))
+}
+
+func quickTimeSeconds(t time.Time) uint64 {
+	return uint64(t.Unix() - quickTimeEpoch.Unix())
+}
+
+// buildMovie builds a movie with a version 0 movie header and a version 1 media header
+func buildMovie(brand string, created time.Time, mediaCreated time.Time) []byte {
+	mvhd := isoBoxBytes("mvhd", []byte{0, 0, 0, 0}, beUint(4, quickTimeSeconds(created)), beUint(4, quickTimeSeconds(created)), make([]byte, 88))
+	mdhd := isoBoxBytes("mdhd", []byte{1, 0, 0, 0}, beUint(8, quickTimeSeconds(mediaCreated)), beUint(8, 0), make([]byte, 16))
+	trak := isoBoxBytes("trak", isoBoxBytes("tkhd", make([]byte, 84)), isoBoxBytes("mdia", mdhd))
+	moov := isoBoxBytes("moov", mvhd, trak)
+	mdat := isoBoxBytes("mdat", make([]byte, 32))
+	if brand == "" {
+		return join(isoBoxBytes("wide"), mdat, moov)
+	}
+	return join(isoBoxBytes("ftyp", []byte(brand), beUint(4, 0)), moov, mdat)
+}
+
+func TestNativeExtractor(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name string, content []byte) string {
+		f := filepath.Join(tmpDir, name)
+		assert.Nil(t, os.WriteFile(f, content, 0666))
+		return f
+	}
+	exifTags := []tiffTag{{0x9003, "2019:04:04 13:18:04"}, {0x9011, "+02:00"}}
+	created := time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)
+	mediaCreated := time.Date(2019, time.April, 4, 13, 18, 5, 0, time.UTC)
+
+	var tcs = []struct {
+		tcID      string
+		file      string
+		expErr    bool
+		expFields map[string]interface{}
+	}{
+		{"jpeg", "../testdata/input/20190404_131804.jpg", false, map[string]interface{}{
+			"FileType": "JPEG", "MIMEType": "image/jpeg", "Make": "samsung", "Model": "SM-G930F",
+			"DateTimeOriginal": "2019:04:04 13:18:03", "CreateDate": "2019:04:04 13:18:03", "SubSecTimeOriginal": "0937",
+		}},
+		{"tiffBigEndian", write("a.tif", buildTIFF(binary.BigEndian, []tiffTag{{0x010f, "abc"}, {0x0110, "Model X"}}, exifTags)), false, map[string]interface{}{
+			"FileType": "TIFF", "MIMEType": "image/tiff", "Make": "abc", "Model": "Model X",
+			"DateTimeOriginal": "2019:04:04 13:18:04", "OffsetTimeOriginal": "+02:00",
+		}},
+		{"heicIdat", write("a.heic", buildHEIC(buildTIFF(binary.LittleEndian, nil, exifTags), true)), false, map[string]interface{}{
+			"FileType": "HEIC", "MIMEType": "image/heic", "DateTimeOriginal": "2019:04:04 13:18:04", "OffsetTimeOriginal": "+02:00",
+		}},
+		{"heicMdat", write("b.heic", buildHEIC(buildTIFF(binary.BigEndian, nil, exifTags), false)), false, map[string]interface{}{
+			"FileType": "HEIC", "MIMEType": "image/heic", "DateTimeOriginal": "2019:04:04 13:18:04",
+		}},
+		{"mp4", write("a.mp4", buildMovie("isom", created, mediaCreated)), false, map[string]interface{}{
+			"FileType": "MP4", "MIMEType": "video/mp4", "CreateDate": "2019:04:04 13:18:04", "MediaCreateDate": "2019:04:04 13:18:05", "MediaModifyDate": zeroDateTime,
+		}},
+		{"mov", write("a.mov", buildMovie("qt  ", created, mediaCreated)), false, map[string]interface{}{
+			"FileType": "MOV", "MIMEType": "video/quicktime", "CreateDate": "2019:04:04 13:18:04",
+		}},
+		{"movWithoutFileType", write("b.mov", buildMovie("", created, mediaCreated)), false, map[string]interface{}{
+			"FileType": "MOV", "CreateDate": "2019:04:04 13:18:04", "MediaCreateDate": "2019:04:04 13:18:05",
+		}},
+		{"text", "../testdata/input/subFolder/noDate.txt", false, map[string]interface{}{
+			"FileName": "noDate.txt",
+		}},
+		{"truncatedJPEG", write("t.jpg", []byte{0xff, 0xd8, 0xff, 0xe1, 0x10, 0x00, 'E', 'x', 'i', 'f', 0, 0, 'I', 'I'}), true, nil},
+		{"invalidTIFF", write("t.tif", []byte("II*\x00\xff\xff\xff\xff")), true, nil},
+		{"invalidBox", write("t.mp4", []byte{0, 0, 0, 4, 'f', 't', 'y', 'p'}), true, nil},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			fms := nativeExtractor{}.ExtractMetadata(tc.file)
+			assert.Len(t, fms, 1)
+			assert.Equal(t, tc.file, fms[0].File)
+			assert.Equal(t, tc.expErr, fms[0].Err != nil)
+			for k, v := range tc.expFields {
+				assert.Equal(t, v, fms[0].Fields[k], k)
+			}
+		})
+	}
+}
+
+func TestNativeExtractorNonExisting(t *testing.T) {
+	fms := nativeExtractor{}.ExtractMetadata("../testdata/input/nonExisting.jpg")
+	assert.Equal(t, exiftool.ErrNotExist, fms[0].Err)
+}
+
+func TestOptMetadataExtractor(t *testing.T) {
+	_, err := NewDateDispatcher(OptMetadataExtractor(ExtractorNative))
+	assert.Nil(t, err)
+	_, err = NewDateDispatcher(OptMetadataExtractor("magic"))
+	assert.NotNil(t, err)
+}
+
+func TestDispatchNativeExtractor(t *testing.T) {
+	tmpDir := t.TempDir()
+	inDir := filepath.Join(tmpDir, "in")
+	assert.Nil(t, os.Mkdir(inDir, 0777))
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", filepath.Join(inDir, "a.jpg")))
+	assert.Nil(t, os.WriteFile(filepath.Join(inDir, "b.mp4"), buildMovie("isom", time.Date(2018, time.May, 6, 7, 8, 9, 0, time.UTC), time.Date(2018, time.May, 6, 7, 8, 9, 0, time.UTC)), 0666))
+	outDir := filepath.Join(tmpDir, "out")
+	assert.Nil(t, os.Mkdir(outDir, 0777))
+
+	c, err := NewDateDispatcher(
+		OptMetadataExtractor(ExtractorNative),
+		OptExiftoolPath(filepath.Join(tmpDir, "nonExisting")),
+		OptOrderedDateFields([]DateField{{Field: "DateTimeOriginal", Pattern: "2006:01:02 15:04:05"}, {Field: "CreateDate", Pattern: "2006:01:02 15:04:05"}}),
+	)
+	assert.Nil(t, err)
+	report, err := c.Dispatch(inDir, outDir)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.FilesTransferred)
+	checkExist(t, filepath.Join(outDir, "2019_04", "a.jpg"), true)
+	checkExist(t, filepath.Join(outDir, "2018_05", "b.mp4"), true)
+}
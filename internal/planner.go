@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+)
+
+// PlannedMove describes a move that would be performed by a dispatch
+type PlannedMove struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Source    string `json:"source"`
+	Collision string `json:"collision,omitempty"`
+	// Duplicate is the file having the same content, if duplicates are detected
+	Duplicate string `json:"duplicate,omitempty"`
+}
+
+func (dd *DateDispatcher) planFiles(ctx context.Context, cancel context.CancelFunc, outputFolder string, actionChan chan moveAction) []PlannedMove {
+	plan := []PlannedMove{}
+	planned := make(map[string]string)
+	names := newRenamer(dd.renamePattern)
+	if names != nil {
+		actionChan = sortedActions(actionChan)
+	}
+	var idx *duplicateIndex
+	if dd.duplicatePolicy != "" {
+		var err error
+		if idx, err = buildDuplicateIndex(outputFolder); err != nil {
+			log.Warn().Msgf("duplicates won't be detected: %v", err)
+		}
+	}
+	for ma := range actionChan {
+		pm := PlannedMove{
+			From:   ma.from,
+			To:     filepath.Join(outputFolder, names.rename(ma.to, ma.date)),
+			Source: ma.source,
+		}
+		if idx != nil {
+			if existing, hash, err := idx.find(ma.from); err == nil && existing != "" {
+				pm.Duplicate = existing
+			} else if info, err := os.Stat(ma.from); err == nil {
+				// files of the dispatch are duplicates of each other as well
+				idx.add(ma.from, info.Size(), hash)
+			}
+		}
+		if prev, found := planned[pm.To]; found {
+			pm.Collision = plannedResolution(dd.collisionPolicy, ma.from, prev)
+		} else if _, col, err := resolveCollision(dd.collisionPolicy, ma.from, pm.To); err == nil && col != nil {
+			pm.Collision = col.Resolution
+		}
+		if pm.Collision == resolutionRenamed {
+			// suffixed like the dispatch would, the names planned for other files being taken
+			if to, err := availableNameAmong(pm.To, planned); err == nil {
+				pm.To = to
+			}
+		}
+		planned[pm.To] = ma.from
+		plan = append(plan, pm)
+	}
+	sort.Slice(plan, func(i, j int) bool {
+		return plan[i].From < plan[j].From
+	})
+	return plan
+}
+
+// plannedResolution guesses how a collision between two files of the same dispatch
+// would be resolved
+func plannedResolution(policy string, from string, previous string) string {
+	switch policy {
+	case CollisionSkip:
+		return resolutionSkipped
+	case CollisionOverwrite:
+		return resolutionOverwritten
+	case CollisionHash:
+		if same, err := sameContent(from, previous); err == nil && same {
+			return resolutionDropped
+		}
+	}
+	return resolutionRenamed
+}
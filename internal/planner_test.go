@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	outDir := filepath.Join(tmpDir, "out")
+	assert.Nil(t, os.MkdirAll(filepath.Join(outDir, "2019_04"), 0777))
+	assert.Nil(t, copy("../testdata/input/subFolder/noDate.txt", filepath.Join(outDir, "2019_04", "c.jpg")))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	actionChan := make(chan moveAction, 5)
+	actionChan <- moveAction{from: "in/a/a.jpg", to: filepath.Join("2019_04", "a.jpg"), source: "fileName"}
+	actionChan <- moveAction{from: "in/b/a.jpg", to: filepath.Join("2019_04", "a.jpg"), source: "CreateDate"}
+	actionChan <- moveAction{from: "in/c/a.jpg", to: filepath.Join("2019_04", "a.jpg"), source: "CreateDate"}
+	actionChan <- moveAction{from: "in/c.jpg", to: filepath.Join("2019_04", "c.jpg"), source: "CreateDate"}
+	actionChan <- moveAction{from: "in/d/c.jpg", to: filepath.Join("2019_04", "c.jpg"), source: "CreateDate"}
+	close(actionChan)
+
+	c := buildDefaultDateDispatcher(t, 1)
+	plan := c.planFiles(ctx, cancel, outDir, actionChan)
+
+	exp := []PlannedMove{
+		{From: "in/a/a.jpg", To: filepath.Join(outDir, "2019_04", "a.jpg"), Source: "fileName"},
+		{From: "in/b/a.jpg", To: filepath.Join(outDir, "2019_04", "a_1.jpg"), Source: "CreateDate", Collision: resolutionRenamed},
+		{From: "in/c.jpg", To: filepath.Join(outDir, "2019_04", "c_1.jpg"), Source: "CreateDate", Collision: resolutionRenamed},
+		{From: "in/c/a.jpg", To: filepath.Join(outDir, "2019_04", "a_2.jpg"), Source: "CreateDate", Collision: resolutionRenamed},
+		{From: "in/d/c.jpg", To: filepath.Join(outDir, "2019_04", "c_2.jpg"), Source: "CreateDate", Collision: resolutionRenamed},
+	}
+	assert.Equal(t, exp, plan)
+	checkExist(t, filepath.Join(outDir, "2019_04", "a.jpg"), false)
+}
+
+func TestPlanFilesDuplicates(t *testing.T) {
+	tmpDir := t.TempDir()
+	outDir := filepath.Join(tmpDir, "out")
+	existing := filepath.Join(outDir, "2018_01", "old.jpg")
+	assert.Nil(t, os.MkdirAll(filepath.Dir(existing), 0777))
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", existing))
+	dup := filepath.Join(tmpDir, "dup.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", dup))
+	other := filepath.Join(tmpDir, "other.txt")
+	assert.Nil(t, copy("../testdata/input/subFolder/noDate.txt", other))
+	otherCopy := filepath.Join(tmpDir, "other_copy.txt")
+	assert.Nil(t, copy("../testdata/input/subFolder/noDate.txt", otherCopy))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	actionChan := make(chan moveAction, 3)
+	actionChan <- moveAction{from: dup, to: filepath.Join("2019_04", "dup.jpg"), source: "CreateDate"}
+	actionChan <- moveAction{from: other, to: filepath.Join("2019_04", "other.txt"), source: "CreateDate"}
+	actionChan <- moveAction{from: otherCopy, to: filepath.Join("2019_04", "other_copy.txt"), source: "CreateDate"}
+	close(actionChan)
+
+	c, err := NewDateDispatcher(OptDuplicatePolicy(DuplicateSkip))
+	assert.Nil(t, err)
+	plan := c.planFiles(ctx, cancel, outDir, actionChan)
+
+	exp := []PlannedMove{
+		{From: dup, To: filepath.Join(outDir, "2019_04", "dup.jpg"), Source: "CreateDate", Duplicate: existing},
+		{From: other, To: filepath.Join(outDir, "2019_04", "other.txt"), Source: "CreateDate"},
+		{From: otherCopy, To: filepath.Join(outDir, "2019_04", "other_copy.txt"), Source: "CreateDate", Duplicate: other},
+	}
+	assert.Equal(t, exp, plan)
+}
@@ -0,0 +1,50 @@
+//go:build linux
+// +build linux
+
+package internal
+
+import (
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl request number (linux/fs.h)
+const ficlone = 0x40049409
+
+// reflink clones the file to a temporary file, preserves permissions and modification time
+// and renames it to its destination, so that an existing destination is left untouched if
+// the clone fails
+func reflink(from, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	part := to + partExt
+	destination, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, destination.Fd(), ficlone, source.Fd())
+	if errno != 0 {
+		destination.Close()
+		os.Remove(part)
+		return errno
+	}
+	err = destination.Close()
+	if err == nil {
+		err = preserveAttributes(part, info)
+	}
+	if err == nil {
+		err = os.Rename(part, to)
+	}
+	if err != nil {
+		os.Remove(part)
+		return err
+	}
+	return nil
+}
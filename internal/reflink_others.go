@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package internal
+
+func reflink(from, to string) error {
+	return errReflinkUnsupported
+}
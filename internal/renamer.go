@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// renamer renames the dispatched files after their date. The names given during a dispatch
+// are kept so that files taken during the same second (bursts) get distinct names. A nil
+// renamer keeps the original names.
+type renamer struct {
+	pattern string
+	claimed map[string]bool
+}
+
+func newRenamer(pattern string) *renamer {
+	if pattern == "" {
+		return nil
+	}
+	return &renamer{pattern: pattern, claimed: make(map[string]bool)}
+}
+
+// rename replaces the file name of to (the extension is kept) with d formatted using the
+// pattern. If the name has already been given, it is suffixed with the sub-second part of d
+// if any, then with the first free counter.
+func (r *renamer) rename(to string, d time.Time) string {
+	if r == nil || d.IsZero() {
+		return to
+	}
+	ext := filepath.Ext(to)
+	base := filepath.Join(filepath.Dir(to), d.Format(r.pattern))
+	candidates := []string{base + ext}
+	if d.Nanosecond() != 0 {
+		candidates = append(candidates, fmt.Sprintf("%v_%v%v", base, strings.TrimPrefix(d.Format(".000"), "."), ext))
+	}
+	for _, c := range candidates {
+		if !r.claimed[c] {
+			r.claimed[c] = true
+			return c
+		}
+	}
+	for i := 1; ; i++ {
+		c := fmt.Sprintf("%v_%v%v", base, i, ext)
+		if !r.claimed[c] {
+			r.claimed[c] = true
+			return c
+		}
+	}
+}
+
+// checkRenamePattern ensures that a date formatted with pattern is a valid file name
+func checkRenamePattern(pattern string) error {
+	name := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC).Format(pattern)
+	if strings.TrimSpace(name) == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("rename pattern %v does not produce a valid file name", pattern)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRename(t *testing.T) {
+	d := time.Date(2019, time.April, 4, 13, 18, 4, 0, time.UTC)
+	sub := time.Date(2019, time.April, 4, 13, 18, 4, 250000000, time.UTC)
+	r := newRenamer("20060102_150405")
+
+	var tcs = []struct {
+		tcID  string
+		to    string
+		date  time.Time
+		expTo string
+	}{
+		{"nominal", filepath.Join("2019_04", "DSC_0042.JPG"), d, filepath.Join("2019_04", "20190404_131804.JPG")},
+		{"burstSubSecond", filepath.Join("2019_04", "DSC_0043.JPG"), sub, filepath.Join("2019_04", "20190404_131804_250.JPG")},
+		{"burstCounter", filepath.Join("2019_04", "DSC_0044.JPG"), d, filepath.Join("2019_04", "20190404_131804_1.JPG")},
+		{"burstSubSecondTaken", filepath.Join("2019_04", "DSC_0045.JPG"), sub, filepath.Join("2019_04", "20190404_131804_2.JPG")},
+		{"otherExtension", filepath.Join("2019_04", "DSC_0042.MOV"), d, filepath.Join("2019_04", "20190404_131804.MOV")},
+		{"otherFolder", filepath.Join("2019_05", "DSC_0042.JPG"), d, filepath.Join("2019_05", "20190404_131804.JPG")},
+		{"noDate", filepath.Join("2019_04", "DSC_0046.JPG"), time.Time{}, filepath.Join("2019_04", "DSC_0046.JPG")},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			assert.Equal(t, tc.expTo, r.rename(tc.to, tc.date))
+		})
+	}
+}
+
+func TestNilRenamer(t *testing.T) {
+	r := newRenamer("")
+	assert.Nil(t, r)
+	assert.Equal(t, "a.jpg", r.rename("a.jpg", time.Now()))
+}
+
+func TestOptRenamePattern(t *testing.T) {
+	var tcs = []struct {
+		tcID    string
+		pattern string
+		expErr  bool
+	}{
+		{"nominal", "20060102_150405", false},
+		{"subSeconds", "20060102_150405.000", false},
+		{"empty", "", true},
+		{"separator", "2006/01/02", true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			_, err := NewDateDispatcher(OptRenamePattern(tc.pattern))
+			assert.Equal(t, tc.expErr, err != nil)
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// Report describes what happened during a dispatch
+type Report struct {
+	FilesScanned        int            `json:"filesScanned"`
+	FilesExcluded       int            `json:"filesExcluded"`
+	FilesTransferred    int            `json:"filesTransferred"`
+	SkippedNoDate       int            `json:"skippedNoDate"`
+	UnparsableDates     int            `json:"unparsableDates"`
+	ImplausibleDates    int            `json:"implausibleDates"`
+	MetadataErrors      int            `json:"metadataErrors"`
+	Collisions          []Collision    `json:"collisions"`
+	Renames             []Rename       `json:"renames"`
+	Duplicates          []Duplicate    `json:"duplicates"`
+	LiveVideosRemoved   int            `json:"liveVideosRemoved"`
+	LiveVideosKept      int            `json:"liveVideosKept"`
+	ResumedFiles        int            `json:"resumedFiles"`
+	CachedFiles         int            `json:"cachedFiles"`
+	PartialFilesCleaned int            `json:"partialFilesCleaned"`
+	BytesTransferred    int64          `json:"bytesTransferred"`
+	DurationSeconds     float64        `json:"durationSeconds"`
+	FileErrors          map[string]int `json:"fileErrors"`
+}
+
+// Collision describes a file whose name was already used in the output folder
+type Collision struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Resolution string `json:"resolution"`
+}
+
+// Rename describes a file renamed after its date
+type Rename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Duplicate describes a file whose content already existed in the output folder. To is
+// where it has been quarantined or linked, if any.
+type Duplicate struct {
+	From     string `json:"from"`
+	Existing string `json:"existing"`
+	To       string `json:"to,omitempty"`
+	Action   string `json:"action"`
+}
+
+// dispatchStats gathers what happened during a dispatch, it is shared by the stages of the
+// pipeline
+type dispatchStats struct {
+	mutex      sync.Mutex
+	start      time.Time
+	errors     []error
+	fileErrors map[string]int
+	report     Report
+}
+
+func newDispatchStats() *dispatchStats {
+	return &dispatchStats{
+		start:      time.Now(),
+		fileErrors: make(map[string]int),
+		report:     Report{Collisions: []Collision{}, Renames: []Rename{}, Duplicates: []Duplicate{}},
+	}
+}
+
+func (s *dispatchStats) addError(err error) {
+	if err == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.errors = append(s.errors, err)
+}
+
+func (s *dispatchStats) addFileError(kind string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fileErrors[kind]++
+}
+
+// update applies f to the report while holding the lock
+func (s *dispatchStats) update(f func(r *Report)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	f(&s.report)
+}
+
+// err returns a *DispatchError if something went wrong, nil otherwise
+func (s *dispatchStats) err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.errors) == 0 && len(s.fileErrors) == 0 {
+		return nil
+	}
+	return &DispatchError{Errors: append([]error{}, s.errors...), FileErrors: s.copyFileErrors()}
+}
+
+func (s *dispatchStats) copyFileErrors() map[string]int {
+	fe := make(map[string]int, len(s.fileErrors))
+	for k, v := range s.fileErrors {
+		fe[k] = v
+	}
+	return fe
+}
+
+// buildReport returns the report, completed with error counts and duration
+func (s *dispatchStats) buildReport() Report {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r := s.report
+	r.Collisions = append([]Collision{}, s.report.Collisions...)
+	r.Renames = append([]Rename{}, s.report.Renames...)
+	r.Duplicates = append([]Duplicate{}, s.report.Duplicates...)
+	r.FileErrors = s.copyFileErrors()
+	r.UnparsableDates = s.fileErrors[dateFileError]
+	r.MetadataErrors = s.fileErrors[metadataFileError]
+	r.DurationSeconds = time.Since(s.start).Seconds()
+	return r
+}
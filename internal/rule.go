@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+const mimeTypeField = "MIMEType"
+
+// Rule customizes how the files it matches are dispatched. A file matches a rule if its
+// name matches one of Globs, its extension one of Extensions or its MIME type (as reported
+// by exiftool) one of MimeTypes, case insensitively. A rule without criteria matches every
+// file.
+//
+// DateFields, OutputTemplate and TransferMode override the dispatcher configuration for
+// the matched files when they are defined.
+type Rule struct {
+	Name           string
+	Globs          []string
+	Extensions     []string
+	MimeTypes      []string
+	DateFields     []DateField
+	OutputTemplate string
+	TransferMode   string
+}
+
+type rule struct {
+	Rule
+	outputTemplate *template.Template
+}
+
+// OptRules registers dispatch rules, the first rule matching a file is applied
+func OptRules(rules []Rule) func(*DateDispatcher) error {
+	return func(c *DateDispatcher) error {
+		for _, r := range rules {
+			cr := rule{Rule: r}
+			for _, g := range r.Globs {
+				if _, err := filepath.Match(strings.ToLower(g), ""); err != nil {
+					return fmt.Errorf("invalid glob %v in rule %v: %w", g, r.Name, err)
+				}
+			}
+			for _, f := range r.DateFields {
+				if err := c.checkDateField(f); err != nil {
+					return fmt.Errorf("error in rule %v: %w", r.Name, err)
+				}
+			}
+			if r.OutputTemplate != "" {
+				t, err := parseOutputTemplate(r.OutputTemplate)
+				if err != nil {
+					return fmt.Errorf("error while parsing output template of rule %v: %w", r.Name, err)
+				}
+				cr.outputTemplate = t
+			}
+			if _, found := transferModes[r.TransferMode]; r.TransferMode != "" && !found {
+				return fmt.Errorf("unsupported transfer mode in rule %v: %v", r.Name, r.TransferMode)
+			}
+			c.rules = append(c.rules, cr)
+		}
+		return nil
+	}
+}
+
+func (r rule) matches(file string, fields map[string]interface{}) bool {
+	if len(r.Globs) == 0 && len(r.Extensions) == 0 && len(r.MimeTypes) == 0 {
+		return true
+	}
+	name := strings.ToLower(filepath.Base(file))
+	for _, g := range r.Globs {
+		if m, _ := filepath.Match(strings.ToLower(g), name); m {
+			return true
+		}
+	}
+	ext := strings.TrimPrefix(filepath.Ext(name), ".")
+	for _, e := range r.Extensions {
+		if ext != "" && strings.EqualFold(strings.TrimPrefix(e, "."), ext) {
+			return true
+		}
+	}
+	mime := stringField(fields, mimeTypeField)
+	for _, m := range r.MimeTypes {
+		if mime != "" && strings.EqualFold(m, mime) {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatchSettings is how a file is dispatched once the rules have been applied
+type dispatchSettings struct {
+	rule           string
+	dateFields     []DateField
+	outputTemplate *template.Template
+	transferMode   string
+}
+
+// settings returns how a file has to be dispatched, according to the first matching rule
+func (dd *DateDispatcher) settings(file string, fields map[string]interface{}) dispatchSettings {
+	s := dispatchSettings{
+		dateFields:     dd.dateFields,
+		outputTemplate: dd.outputTemplate,
+		transferMode:   dd.transferMode,
+	}
+	for _, r := range dd.rules {
+		if !r.matches(file, fields) {
+			continue
+		}
+		s.rule = r.Name
+		if len(r.DateFields) > 0 {
+			s.dateFields = r.DateFields
+		}
+		if r.outputTemplate != nil {
+			s.outputTemplate = r.outputTemplate
+		}
+		if r.TransferMode != "" {
+			s.transferMode = r.TransferMode
+		}
+		break
+	}
+	return s
+}
+
+// transferModeWithoutMetadata returns the transfer mode of file according to the rules,
+// known is false if it depends on the metadata of the file (a rule matching MIME types may
+// apply)
+func (dd *DateDispatcher) transferModeWithoutMetadata(file string) (mode string, known bool) {
+	for _, r := range dd.rules {
+		if r.matches(file, nil) {
+			if r.TransferMode != "" {
+				return r.TransferMode, true
+			}
+			return dd.transferMode, true
+		}
+		if len(r.MimeTypes) > 0 {
+			return "", false
+		}
+	}
+	return dd.transferMode, true
+}
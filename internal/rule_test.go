@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleMatches(t *testing.T) {
+	var tcs = []struct {
+		tcID     string
+		rule     Rule
+		file     string
+		fields   map[string]interface{}
+		expMatch bool
+	}{
+		{"catchAll", Rule{}, "a.jpg", nil, true},
+		{"glob", Rule{Globs: []string{"*.cr2", "*.nef"}}, "/in/IMG_0001.CR2", nil, true},
+		{"globNoMatch", Rule{Globs: []string{"*.cr2"}}, "/in/IMG_0001.JPG", nil, false},
+		{"extension", Rule{Extensions: []string{"mov", ".MP4"}}, "/in/clip.mp4", nil, true},
+		{"extensionNoMatch", Rule{Extensions: []string{"mov"}}, "/in/mov", nil, false},
+		{"mime", Rule{MimeTypes: []string{"video/quicktime"}}, "/in/clip", map[string]interface{}{"MIMEType": "video/QuickTime"}, true},
+		{"mimeNoMatch", Rule{MimeTypes: []string{"video/quicktime"}}, "/in/a.jpg", map[string]interface{}{"MIMEType": "image/jpeg"}, false},
+		{"mimeMissing", Rule{MimeTypes: []string{"video/quicktime"}}, "/in/a.jpg", nil, false},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			assert.Equal(t, tc.expMatch, rule{Rule: tc.rule}.matches(tc.file, tc.fields))
+		})
+	}
+}
+
+func TestSettings(t *testing.T) {
+	videoFields := []DateField{{Field: "Media Create Date", Pattern: "2006:01:02 15:04:05", Zone: "UTC"}}
+	c, err := NewDateDispatcher(
+		OptOrderedDateFields([]DateField{{Field: "CreateDate", Pattern: "2006:01:02 15:04:05"}}),
+		OptRules([]Rule{
+			{Name: "videos", Extensions: []string{"mov", "mp4"}, DateFields: videoFields, OutputTemplate: "videos/{{.Year}}/{{.Name}}{{.Ext}}", TransferMode: TransferCopy},
+			{Name: "raw", Globs: []string{"*.cr2"}, TransferMode: TransferHardlink},
+			{Name: "duplicate", Extensions: []string{"mov"}, TransferMode: TransferSymlink},
+		}),
+	)
+	assert.Nil(t, err)
+
+	s := c.settings("clip.MOV", nil)
+	assert.Equal(t, "videos", s.rule)
+	assert.Equal(t, videoFields, s.dateFields)
+	assert.NotNil(t, s.outputTemplate)
+	assert.Equal(t, TransferCopy, s.transferMode)
+
+	s = c.settings("IMG_0001.CR2", nil)
+	assert.Equal(t, "raw", s.rule)
+	assert.Equal(t, c.dateFields, s.dateFields)
+	assert.Nil(t, s.outputTemplate)
+	assert.Equal(t, TransferHardlink, s.transferMode)
+
+	s = c.settings("IMG_0001.JPG", nil)
+	assert.Equal(t, "", s.rule)
+	assert.Equal(t, c.dateFields, s.dateFields)
+	assert.Equal(t, defaultTransferMode, s.transferMode)
+}
+
+func TestOptRulesInvalid(t *testing.T) {
+	var tcs = []struct {
+		tcID string
+		rule Rule
+	}{
+		{"glob", Rule{Name: "r", Globs: []string{"[a-"}}},
+		{"dateField", Rule{Name: "r", DateFields: []DateField{{Field: "CreateDate"}}}},
+		{"template", Rule{Name: "r", OutputTemplate: "{{.Year"}},
+		{"transferMode", Rule{Name: "r", TransferMode: "teleport"}},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			_, err := NewDateDispatcher(OptRules([]Rule{tc.rule}))
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestGetMoveActionsRules(t *testing.T) {
+	tmpDir := t.TempDir()
+	jpgFile := filepath.Join(tmpDir, "IMG_0001.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", jpgFile))
+	movFile := filepath.Join(tmpDir, "CLIP_0001.mov")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", movFile))
+
+	fake := NewFakeExtractor()
+	fake.SetFields(jpgFile, map[string]interface{}{"CreateDate": "2019:04:04 13:18:03"})
+	fake.SetFields(movFile, map[string]interface{}{"CreateDate": "2019:04:04 13:18:03"})
+	c, err := NewDateDispatcher(
+		OptThreadCount(1),
+		OptOrderedDateFields([]DateField{{Field: "CreateDate", Pattern: "2006:01:02 15:04:05"}}),
+		OptRules([]Rule{
+			{Name: "videos", Extensions: []string{"mov"}, OutputTemplate: "videos/{{.Year}}/{{.Name}}{{.Ext}}", TransferMode: TransferCopy},
+		}),
+		OptExtractorFactory(fake.Factory()),
+	)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	fileChan := make(chan string, 2)
+	fileChan <- jpgFile
+	fileChan <- movFile
+	close(fileChan)
+	actionChan := make(chan moveAction, 2)
+	assert.Nil(t, c.getMoveActions(ctx, cancel, fileChan, actionChan, nil, nil, newDispatchStats()))
+
+	date := time.Date(2019, time.April, 4, 13, 18, 3, 0, time.UTC)
+	actions := []moveAction{}
+	for ma := range actionChan {
+		actions = append(actions, ma)
+	}
+	assert.ElementsMatch(t, []moveAction{
+		{from: jpgFile, to: filepath.Join("2019_04", "IMG_0001.jpg"), source: "CreateDate", date: date},
+		{from: movFile, to: filepath.Join("videos", "2019", "CLIP_0001.mov"), source: "CreateDate", date: date, mode: TransferCopy},
+	}, actions)
+}
+
+func TestMoveFilesPerFileMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	outDir := filepath.Join(tmpDir, "out")
+	jpgFile := filepath.Join(tmpDir, "IMG_0001.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", jpgFile))
+	movFile := filepath.Join(tmpDir, "CLIP_0001.mov")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", movFile))
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	actionChan := make(chan moveAction, 2)
+	actionChan <- moveAction{from: jpgFile, to: filepath.Join("2019_04", "IMG_0001.jpg")}
+	actionChan <- moveAction{from: movFile, to: filepath.Join("videos", "2019", "CLIP_0001.mov"), mode: TransferCopy}
+	close(actionChan)
+
+	c, err := NewDateDispatcher()
+	assert.Nil(t, err)
+	c.moveFiles(ctx, cancel, outDir, actionChan, nil, newDispatchStats())
+
+	checkExist(t, jpgFile, false)
+	checkExist(t, filepath.Join(outDir, "2019_04", "IMG_0001.jpg"), true)
+	checkExist(t, movFile, true)
+	checkExist(t, filepath.Join(outDir, "videos", "2019", "CLIP_0001.mov"), true)
+}
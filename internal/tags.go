@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"sort"
+	"text/template"
+	"text/template/parse"
+)
+
+const (
+	makeField  = "Make"
+	modelField = "Model"
+)
+
+// relevantTags returns the exiftool tags the dispatcher needs : date fields, offsets,
+// camera, MIME types (if rules use them) and the fields used by the output templates.
+// all is true if a template uses the metadata in a way that can't be analyzed, so that
+// every tag may be needed.
+func (dd *DateDispatcher) relevantTags() (tags []string, all bool) {
+	set := map[string]bool{makeField: true, modelField: true}
+	addFields := func(fields []DateField) {
+		for _, f := range fields {
+			set[f.Field] = true
+			if f.OffsetField != "" {
+				set[f.OffsetField] = true
+			}
+		}
+	}
+	addTemplate := func(t *template.Template) {
+		if t == nil {
+			return
+		}
+		fields, a := templateFields(t)
+		all = all || a
+		for _, f := range fields {
+			set[f] = true
+		}
+	}
+
+	addFields(dd.dateFields)
+	addTemplate(dd.outputTemplate)
+	for _, r := range dd.rules {
+		addFields(r.DateFields)
+		addTemplate(r.outputTemplate)
+		if len(r.MimeTypes) > 0 {
+			set[mimeTypeField] = true
+		}
+	}
+
+	for t := range set {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	return tags, all
+}
+
+// templateFields returns the metadata fields used by a template (.Fields.X or
+// index .Fields "X"), all is true if the metadata are used in another way
+func templateFields(t *template.Template) (fields []string, all bool) {
+	walkTemplate(t, func(n parse.Node) bool {
+		switch n := n.(type) {
+		case *parse.CommandNode:
+			if f, ok := indexedField(n.Args); ok {
+				fields = append(fields, f)
+				return false
+			}
+		case *parse.FieldNode:
+			fieldIdent(n.Ident, &fields, &all)
+		case *parse.VariableNode:
+			if len(n.Ident) > 1 && n.Ident[0] == "$" {
+				fieldIdent(n.Ident[1:], &fields, &all)
+			} else if len(n.Ident) == 1 && n.Ident[0] == "$" {
+				all = true
+			}
+		case *parse.DotNode:
+			all = true
+		}
+		return true
+	})
+	return fields, all
+}
+
+// templateUsesFileName returns true if a template may use the name or the extension of the
+// file (.Name or .Ext, or the data as a whole)
+func templateUsesFileName(t *template.Template) bool {
+	used := false
+	isFileName := func(ident []string) bool {
+		return len(ident) > 0 && (ident[0] == "Name" || ident[0] == "Ext")
+	}
+	walkTemplate(t, func(n parse.Node) bool {
+		switch n := n.(type) {
+		case *parse.FieldNode:
+			used = used || isFileName(n.Ident)
+		case *parse.VariableNode:
+			used = used || (n.Ident[0] == "$" && (len(n.Ident) == 1 || isFileName(n.Ident[1:])))
+		case *parse.DotNode:
+			used = true
+		}
+		return !used
+	})
+	return used
+}
+
+// indexedField returns X if args are index .Fields "X"
+func indexedField(args []parse.Node) (string, bool) {
+	if len(args) != 3 {
+		return "", false
+	}
+	if id, ok := args[0].(*parse.IdentifierNode); !ok || id.Ident != "index" {
+		return "", false
+	}
+	if f, ok := args[1].(*parse.FieldNode); !ok || len(f.Ident) != 1 || f.Ident[0] != "Fields" {
+		return "", false
+	}
+	s, ok := args[2].(*parse.StringNode)
+	if !ok {
+		return "", false
+	}
+	return s.Text, true
+}
+
+// walkTemplate calls visit for each node of the templates of t, the children of a node are
+// not visited if visit returns false
+func walkTemplate(t *template.Template, visit func(n parse.Node) bool) {
+	var walk func(n parse.Node)
+	walk = func(n parse.Node) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+		}
+		if !visit(n) {
+			return
+		}
+		switch n := n.(type) {
+		case *parse.ListNode:
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			for _, c := range n.Cmds {
+				walk(c)
+			}
+		case *parse.CommandNode:
+			for _, a := range n.Args {
+				walk(a)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		}
+	}
+	for _, tpl := range t.Templates() {
+		if tpl.Tree != nil {
+			walk(tpl.Tree.Root)
+		}
+	}
+}
+
+func fieldIdent(ident []string, fields *[]string, all *bool) {
+	if len(ident) == 0 || ident[0] != "Fields" {
+		return
+	}
+	if len(ident) == 1 {
+		*all = true
+		return
+	}
+	*fields = append(*fields, ident[1])
+}
@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTemplateFields(t *testing.T) {
+	var tcs = []struct {
+		tcID      string
+		template  string
+		expFields []string
+		expAll    bool
+	}{
+		{"noField", "{{.Year}}/{{.Camera.Model}}/{{.Name}}{{.Ext}}", nil, false},
+		{"field", "{{.Fields.LensModel}}/{{.Name}}{{.Ext}}", []string{"LensModel"}, false},
+		{"index", `{{index .Fields "Lens Model"}}/{{.Name}}{{.Ext}}`, []string{"Lens Model"}, false},
+		{"branches", `{{if .Fields.A}}{{.Fields.B}}{{else}}{{with $.Fields.C}}c{{end}}{{end}}/{{.Name}}`, []string{"A", "B", "C"}, false},
+		{"range", `{{range $k, $v := .Fields}}{{$k}}{{end}}/{{.Name}}`, nil, true},
+		{"dot", `{{printf "%v" .}}`, nil, true},
+		{"dotInWith", `{{with .Fields.A}}{{.}}{{end}}/{{.Name}}`, []string{"A"}, true},
+		{"rootVariable", `{{printf "%v" $}}`, nil, true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			tpl, err := parseOutputTemplate(tc.template)
+			assert.Nil(t, err)
+			fields, all := templateFields(tpl)
+			assert.Equal(t, tc.expFields, fields)
+			assert.Equal(t, tc.expAll, all)
+		})
+	}
+}
+
+func TestRelevantTags(t *testing.T) {
+	c, err := NewDateDispatcher(
+		OptOrderedDateFields([]DateField{{Field: "DateTimeOriginal", Pattern: "2006", OffsetField: "OffsetTimeOriginal"}}),
+		OptOutputTemplate("{{.Year}}/{{.Fields.LensModel}}/{{.Name}}{{.Ext}}"),
+		OptRules([]Rule{{Name: "videos", MimeTypes: []string{"video/mp4"}, DateFields: []DateField{{Field: "MediaCreateDate", Pattern: "2006"}}}}),
+	)
+	assert.Nil(t, err)
+	tags, all := c.relevantTags()
+	assert.False(t, all)
+	assert.Equal(t, []string{"DateTimeOriginal", "LensModel", "MIMEType", "Make", "MediaCreateDate", "Model", "OffsetTimeOriginal"}, tags)
+}
@@ -0,0 +1,203 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	TransferMove     = "move"
+	TransferCopy     = "copy"
+	TransferHardlink = "hardlink"
+	TransferSymlink  = "symlink"
+	TransferReflink  = "reflink"
+
+	defaultTransferMode = TransferMove
+
+	// partExt suffixes a file being copied until it is complete and verified, or a link until
+	// it replaces its destination
+	partExt = ".dispatcher-part"
+)
+
+var errReflinkUnsupported = fmt.Errorf("reflink not supported")
+
+// transferModes associates each transfer mode to the function transferring a file to its
+// destination. The destination may already exist if it has to be overwritten.
+var transferModes = map[string]func(from, to string) error{
+	TransferMove:     move,
+	TransferCopy:     copy,
+	TransferHardlink: hardlink,
+	TransferSymlink:  symlink,
+	TransferReflink:  reflinkOrCopy,
+}
+
+// copy copies the file to a temporary file, syncs it to the disk and checks that it
+// matches the source (size and SHA-256) before preserving permissions and modification
+// time and renaming it to its destination. The temporary file is removed if anything goes
+// wrong.
+func copy(from, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+	part := to + partExt
+	destination, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if err = copyContent(source, info, destination); err != nil {
+		os.Remove(part)
+		return err
+	}
+	if err = os.Rename(part, to); err != nil {
+		os.Remove(part)
+		return err
+	}
+	return nil
+}
+
+func copyContent(source *os.File, info os.FileInfo, destination *os.File) error {
+	to := destination.Name()
+	h := sha256.New()
+	if _, err := io.Copy(destination, io.TeeReader(source, h)); err != nil {
+		destination.Close()
+		return err
+	}
+	if err := destination.Sync(); err != nil {
+		destination.Close()
+		return fmt.Errorf("error while syncing %v: %w", to, err)
+	}
+	if err := destination.Close(); err != nil {
+		return fmt.Errorf("error while closing %v: %w", to, err)
+	}
+
+	if err := verifyCopy(to, info.Size(), fmt.Sprintf("%x", h.Sum(nil))); err != nil {
+		return err
+	}
+	return preserveAttributes(to, info)
+}
+
+// preserveAttributes applies the permissions and the modification time of the source
+// (info) to to
+func preserveAttributes(to string, info os.FileInfo) error {
+	if err := os.Chmod(to, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("error while setting permissions of %v: %w", to, err)
+	}
+	if err := os.Chtimes(to, info.ModTime(), info.ModTime()); err != nil {
+		return fmt.Errorf("error while setting modification time of %v: %w", to, err)
+	}
+	return nil
+}
+
+func verifyCopy(to string, expSize int64, expHash string) error {
+	info, err := os.Stat(to)
+	if err != nil {
+		return err
+	}
+	if info.Size() != expSize {
+		return fmt.Errorf("size mismatch after copy (%v vs %v)", expSize, info.Size())
+	}
+	hash, err := hashFile(to)
+	if err != nil {
+		return err
+	}
+	if hash != expHash {
+		return fmt.Errorf("checksum mismatch after copy (%v vs %v)", expHash, hash)
+	}
+	return nil
+}
+
+// move renames the file, which is instant when source and destination share the same file
+// system, and falls back to a copy followed by the removal of the source otherwise. Other
+// rename errors are returned as is.
+func move(from, to string) error {
+	err := os.Rename(from, to)
+	if err == nil || !isCrossDevice(err) {
+		return err
+	}
+	log.Debug().Str(fileLogField, from).Msgf("rename failed (%v), falling back to copy", err)
+	return moveByCopy(from, to)
+}
+
+// moveByCopy only removes the source once the copy has been verified. If the source can't
+// be removed, the copy is removed so that the file is not dispatched twice, unless it has
+// replaced an existing file (the copy is kept then, since the replaced file is lost anyway).
+func moveByCopy(from, to string) error {
+	_, statErr := os.Lstat(to)
+	existed := statErr == nil
+	if err := copy(from, to); err != nil {
+		return fmt.Errorf("%w, source kept", err)
+	}
+	if err := os.Remove(from); err != nil {
+		if existed {
+			return fmt.Errorf("error while removing source, copy kept since it replaced %v: %w", to, err)
+		}
+		if rmErr := os.Remove(to); rmErr != nil {
+			log.Warn().Str(fileLogField, to).Msgf("error while removing copy: %v", rmErr)
+		}
+		return fmt.Errorf("error while removing source: %w", err)
+	}
+	return nil
+}
+
+func removeExisting(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func hardlink(from, to string) error {
+	return linkAndRename(to, func(part string) error {
+		return os.Link(from, part)
+	})
+}
+
+func symlink(from, to string) error {
+	abs, err := filepath.Abs(from)
+	if err != nil {
+		return err
+	}
+	return linkAndRename(to, func(part string) error {
+		return os.Symlink(abs, part)
+	})
+}
+
+// linkAndRename creates a link to a temporary file with link and renames it to its
+// destination, so that an existing destination is only replaced once the link exists
+func linkAndRename(to string, link func(part string) error) error {
+	part := to + partExt
+	if err := removeExisting(part); err != nil {
+		return err
+	}
+	if err := link(part); err != nil {
+		return err
+	}
+	if err := os.Rename(part, to); err != nil {
+		os.Remove(part)
+		return err
+	}
+	// renaming a hard link over another link to the same file does nothing
+	return removeExisting(part)
+}
+
+// reflinkOrCopy clones the file (copy-on-write) when the file system supports it, and
+// copies it otherwise
+func reflinkOrCopy(from, to string) error {
+	err := reflink(from, to)
+	if err == nil {
+		return nil
+	}
+	log.Debug().Str(fileLogField, from).Msgf("reflink failed (%v), falling back to copy", err)
+	return copy(from, to)
+}
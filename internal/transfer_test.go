@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransferModes(t *testing.T) {
+	var tcs = []struct {
+		mode          string
+		expSourceLeft bool
+		expSymlink    bool
+	}{
+		{TransferMove, false, false},
+		{TransferCopy, true, false},
+		{TransferHardlink, true, false},
+		{TransferSymlink, true, true},
+		{TransferReflink, true, false},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.mode, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			from := filepath.Join(tmpDir, "from.jpg")
+			assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", from))
+			to := filepath.Join(tmpDir, "to.jpg")
+			assert.Nil(t, os.WriteFile(to, []byte("to be overwritten"), 0666))
+
+			assert.Nil(t, transferModes[tc.mode](from, to))
+
+			checkExist(t, from, tc.expSourceLeft)
+			info, err := os.Lstat(to)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expSymlink, info.Mode()&os.ModeSymlink != 0)
+			hash, err := hashFile(to)
+			assert.Nil(t, err)
+			expHash, err := hashFile("../testdata/input/20190404_131804.jpg")
+			assert.Nil(t, err)
+			assert.Equal(t, expHash, hash)
+		})
+	}
+}
+
+func TestLinkFailureKeepsExisting(t *testing.T) {
+	tmpDir := t.TempDir()
+	to := filepath.Join(tmpDir, "to.jpg")
+	assert.Nil(t, os.WriteFile(to, []byte("existing"), 0666))
+	checkKept := func() {
+		b, err := os.ReadFile(to)
+		assert.Nil(t, err)
+		assert.Equal(t, "existing", string(b))
+		checkExist(t, to+partExt, false)
+	}
+
+	assert.NotNil(t, hardlink(filepath.Join(tmpDir, "missing.jpg"), to))
+	checkKept()
+	assert.NotNil(t, linkAndRename(to, func(part string) error {
+		return errors.New("links not supported")
+	}))
+	checkKept()
+}
+
+func TestHardlinkOverSameFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	from := filepath.Join(tmpDir, "from.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", from))
+	to := filepath.Join(tmpDir, "to.jpg")
+	assert.Nil(t, os.Link(from, to))
+
+	assert.Nil(t, hardlink(from, to))
+	checkExist(t, to, true)
+	checkExist(t, to+partExt, false)
+}
+
+func TestMoveByCopy(t *testing.T) {
+	tmpDir := t.TempDir()
+	from := filepath.Join(tmpDir, "from.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", from))
+	to := filepath.Join(tmpDir, "to.jpg")
+
+	assert.Nil(t, moveByCopy(from, to))
+	checkExist(t, from, false)
+	checkExist(t, to, true)
+}
+
+func TestCopyPreservesAttributes(t *testing.T) {
+	tmpDir := t.TempDir()
+	from := filepath.Join(tmpDir, "from.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", from))
+	assert.Nil(t, os.Chmod(from, 0640))
+	mtime := time.Date(2019, time.April, 4, 13, 18, 4, 0, time.Local)
+	assert.Nil(t, os.Chtimes(from, mtime, mtime))
+	to := filepath.Join(tmpDir, "to.jpg")
+
+	assert.Nil(t, copy(from, to))
+	info, err := os.Stat(to)
+	assert.Nil(t, err)
+	assert.True(t, mtime.Equal(info.ModTime()))
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	}
+}
+
+func TestVerifyCopy(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", file))
+	info, err := os.Stat(file)
+	assert.Nil(t, err)
+	hash, err := hashFile(file)
+	assert.Nil(t, err)
+
+	assert.Nil(t, verifyCopy(file, info.Size(), hash))
+	assert.NotNil(t, verifyCopy(file, info.Size()+1, hash))
+	assert.NotNil(t, verifyCopy(file, info.Size(), "0000"))
+}
+
+func TestMoveByCopyFailureKeepsSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	from := filepath.Join(tmpDir, "from.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", from))
+
+	assert.NotNil(t, moveByCopy(from, filepath.Join(tmpDir, "missing", "to.jpg")))
+	checkExist(t, from, true)
+}
+
+func TestMoveByCopyRemovalFailure(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("permissions can't prevent the removal of the source")
+	}
+	var tcs = []struct {
+		tcID        string
+		existing    bool
+		expCopyLeft bool
+	}{
+		{"removesCopy", false, false},
+		{"keepsCopyReplacingExisting", true, true},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.tcID, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			inDir := filepath.Join(tmpDir, "in")
+			assert.Nil(t, os.Mkdir(inDir, 0777))
+			from := filepath.Join(inDir, "from.jpg")
+			assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", from))
+			assert.Nil(t, os.Chmod(inDir, 0555))
+			defer os.Chmod(inDir, 0777)
+			to := filepath.Join(tmpDir, "to.jpg")
+			if tc.existing {
+				assert.Nil(t, os.WriteFile(to, []byte("overwritten"), 0666))
+			}
+
+			assert.NotNil(t, moveByCopy(from, to))
+			checkExist(t, from, true)
+			checkExist(t, to, tc.expCopyLeft)
+			if tc.expCopyLeft {
+				hash, err := hashFile(to)
+				assert.Nil(t, err)
+				expHash, err := hashFile(from)
+				assert.Nil(t, err)
+				assert.Equal(t, expHash, hash)
+			}
+		})
+	}
+}
+
+func TestMoveReturnsRenameErrors(t *testing.T) {
+	tmpDir := t.TempDir()
+	from := filepath.Join(tmpDir, "from.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", from))
+
+	// renaming a file over a non-empty directory fails, it is not worth a copy
+	to := filepath.Join(tmpDir, "to.jpg")
+	assert.Nil(t, os.MkdirAll(filepath.Join(to, "sub"), 0777))
+	err := move(from, to)
+	var linkErr *os.LinkError
+	assert.True(t, errors.As(err, &linkErr))
+	assert.False(t, isCrossDevice(err))
+	checkExist(t, from, true)
+}
+
+func TestIsCrossDevice(t *testing.T) {
+	assert.True(t, isCrossDevice(&os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.EXDEV}))
+	assert.False(t, isCrossDevice(&os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.ENOENT}))
+}
+
+func TestOptTransferModeUnsupported(t *testing.T) {
+	_, err := NewDateDispatcher(OptTransferMode("teleport"))
+	assert.NotNil(t, err)
+}
+
+func TestMoveFilesCopyMode(t *testing.T) {
+	tmpDir := t.TempDir()
+	inFile := filepath.Join(tmpDir, "20190404_131804.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", inFile))
+	outDir := filepath.Join(tmpDir, "out")
+
+	actionChan := make(chan moveAction, 1)
+	actionChan <- moveAction{from: inFile, to: filepath.Join("2019_04", "20190404_131804.jpg")}
+	close(actionChan)
+
+	c, err := NewDateDispatcher(OptTransferMode(TransferCopy))
+	assert.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.TODO())
+	c.moveFiles(ctx, cancel, outDir, actionChan, nil, newDispatchStats())
+
+	checkExist(t, inFile, true)
+	checkExist(t, filepath.Join(outDir, "2019_04", "20190404_131804.jpg"), true)
+}
+
+func TestReflink(t *testing.T) {
+	tmpDir := t.TempDir()
+	from := filepath.Join(tmpDir, "from.jpg")
+	assert.Nil(t, copy("../testdata/input/20190404_131804.jpg", from))
+	mtime := time.Date(2019, time.April, 4, 13, 18, 4, 0, time.Local)
+	assert.Nil(t, os.Chtimes(from, mtime, mtime))
+	to := filepath.Join(tmpDir, "to.jpg")
+	assert.Nil(t, os.WriteFile(to, []byte("existing"), 0666))
+
+	err := reflink(from, to)
+	checkExist(t, to+partExt, false)
+	if err != nil {
+		// the file system doesn't support clones, the destination is left untouched
+		b, rErr := os.ReadFile(to)
+		assert.Nil(t, rErr)
+		assert.Equal(t, "existing", string(b))
+		t.Skipf("reflink not supported: %v", err)
+	}
+	info, err := os.Stat(to)
+	assert.Nil(t, err)
+	assert.True(t, mtime.Equal(info.ModTime()))
+	expHash, err := hashFile(from)
+	assert.Nil(t, err)
+	hash, err := hashFile(to)
+	assert.Nil(t, err)
+	assert.Equal(t, expHash, hash)
+}